@@ -1,9 +1,9 @@
 repomix --no-file-summary --no-security-check \
-  --include "src/Dockerfile,src/backup.go,src/docker.go,src/dropbox.go,src/logger.go,src/main.go,src/retention.go,src/types.go,src/entrypoint.sh,src/functions.sh" \
+  --include "src/Dockerfile,src/backup.go,src/docker.go,src/dropbox.go,src/logger.go,src/main.go,src/retention.go,src/types.go,src/entrypoint.sh" \
   --output "repopack.yml"
 
 repomix --no-file-summary --no-security-check \
-  --include "src/Dockerfile,src/entrypoint.sh,src/functions.sh" \
+  --include "src/Dockerfile,src/entrypoint.sh" \
   --output "repopack.yml"
 
 go mod tidy
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiry an access token is replaced,
+// so that a request never starts with a token about to expire
+const tokenRefreshMargin = 5 * time.Minute
+
+// authTransport adds the access token of an uploader to every request. It
+// refreshes the token shortly before it expires and replays a request
+// once if Dropbox still reports the token as expired.
+type authTransport struct {
+	uploader *DropboxUploader
+}
+
+// tokenRefreshError reports that no access token could be obtained. The
+// token request has already been retried, so retrying the request it was
+// needed for cannot help, e.g. once the refresh token was revoked.
+type tokenRefreshError struct {
+	err error
+}
+
+func (e *tokenRefreshError) Error() string {
+	return fmt.Sprintf("failed to refresh access token: %v", e.err)
+}
+
+func (e *tokenRefreshError) Unwrap() error {
+	return e.err
+}
+
+// base returns the transport of the uploader's HTTP client
+func (t *authTransport) base() http.RoundTripper {
+	if t.uploader.HTTPClient != nil && t.uploader.HTTPClient.Transport != nil {
+		return t.uploader.HTTPClient.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.uploader.token()
+	if err != nil {
+		return nil, &tokenRefreshError{err: err}
+	}
+
+	resp, err := t.base().RoundTrip(t.withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if !strings.Contains(string(body), "expired_access_token") {
+		return resp, nil
+	}
+
+	// Replay with a fresh token if the body can be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	logSubStep("🔑 Access token expired, refreshing")
+	t.uploader.invalidateToken(token)
+	token, err = t.uploader.token()
+	if err != nil {
+		return nil, &tokenRefreshError{err: err}
+	}
+	retry := t.withToken(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base().RoundTrip(retry)
+}
+
+// withToken returns a copy of req carrying token and the team headers, as
+// a RoundTripper must not modify the request it is given
+func (t *authTransport) withToken(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Authorization", "Bearer "+token)
+	if t.uploader.SelectUser != "" {
+		clone.Header.Set("Dropbox-API-Select-User", t.uploader.SelectUser)
+	}
+	if t.uploader.PathRoot != "" {
+		clone.Header.Set("Dropbox-API-Path-Root", t.uploader.PathRoot)
+	}
+	return clone
+}
+
+// token returns a valid access token, refreshing it if it expires soon
+func (d *DropboxUploader) token() (string, error) {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.accessToken == "" || time.Now().Add(tokenRefreshMargin).After(d.tokenExpiry) {
+		if err := d.refreshAccessToken(); err != nil {
+			return "", err
+		}
+	}
+	return d.accessToken, nil
+}
+
+// invalidateToken forces a refresh on the next request unless another
+// request already replaced the stale token
+func (d *DropboxUploader) invalidateToken(stale string) {
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.accessToken == stale {
+		d.accessToken = ""
+	}
+}
@@ -5,51 +5,147 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
-func processVolumes(container string, volumes []Volume, outputDir string) error {
-	tempDir := filepath.Join(outputDir, "temp", container)
+// packmateImage is fully qualified so Podman does not need short-name
+// resolution to find it
+const packmateImage = "docker.io/dublok/packmate:latest"
+
+// stagingDir is where the per-volume archives of a backup are collected
+// before they are packed into the final archive
+func stagingDir(outputDir, container string) string {
+	return filepath.Join(outputDir, "temp", container)
+}
+
+func processVolumes(ep *DockerEndpoint, container string, volumes []Volume, outputDir string) error {
+	tempDir := stagingDir(outputDir, container)
 	os.MkdirAll(tempDir, 0755)
 
 	// Ensure the latest version of the Packmate image is pulled
-	if err := pullLatestPackmateImage(); err != nil {
+	if err := pullLatestPackmateImage(ep); err != nil {
 		return fmt.Errorf("failed to pull the latest Packmate image: %w", err)
 	}
 
+	// A remote engine cannot bind mount volback's working directory, so
+	// the archives are staged in volumes on that engine instead
+	if !ep.isLocal() {
+		if err := processRemoteVolumes(ep, container, volumes, outputDir); err != nil {
+			return err
+		}
+		return os.RemoveAll(filepath.Join(outputDir, "temp"))
+	}
+
 	for i, volume := range volumes {
-		logHeader("🔸 Volume %d/%d:", i+1, len(volumes))
-		logSubStep("Source: %s", volume.Source)
-		logSubStep("Destination: %s", volume.Destination)
-		logSubStep("Type: %s", volume.Type)
-
-		// Skip tmpfs volumes
-		if volume.Type == "tmpfs" {
-			logSubStep("⏭️  Skipping tmpfs volume")
+		if !logVolume(i, volumes) {
 			continue
 		}
+		if err := backupVolume(ep, volume, tempDir); err != nil {
+			return err
+		}
+	}
 
-		// Skip volumes with empty source
-		if volume.Source == "" {
-			logSubStep("⏭️  Skipping volume with empty source")
-			continue
+	if err := createFinalArchive(ep, container, tempDir, outputDir); err != nil {
+		return err
+	}
+
+	// Verify the file exists
+	finalArchivePath := filepath.Join(outputDir, container+".7z")
+	if _, err := os.Stat(finalArchivePath); os.IsNotExist(err) {
+		return fmt.Errorf("final archive was not created at %s", finalArchivePath)
+	}
+
+	return os.RemoveAll(filepath.Join(outputDir, "temp"))
+}
+
+// processRemoteVolumes archives volumes on a remote engine. Per-volume
+// archives go to a staging volume, the final archive to an output volume,
+// and the result is copied back through a helper container.
+func processRemoteVolumes(ep *DockerEndpoint, container string, volumes []Volume, outputDir string) error {
+	suffix := container + "-" + time.Now().Format("20060102150405")
+	stageVolume := "volback-stage-" + suffix
+	outVolume := "volback-out-" + suffix
+	helper := "volback-copy-" + suffix
+
+	for _, name := range []string{stageVolume, outVolume} {
+		if _, err := executeCommand("docker", ep.cliArgs("volume", "create", name)...); err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", name, err)
 		}
+		defer executeCommand("docker", ep.cliArgs("volume", "rm", "-f", name)...)
+	}
+
+	// The helper is never started; docker cp works on created containers
+	if _, err := executeCommand("docker", ep.cliArgs(
+		"create", "--name", helper,
+		"-v", stageVolume+":/stage",
+		"-v", outVolume+":/out",
+		packmateImage,
+	)...); err != nil {
+		return fmt.Errorf("failed to create helper container: %w", err)
+	}
+	defer executeCommand("docker", ep.cliArgs("rm", "-f", helper)...)
 
-		if err := backupVolume(volume, tempDir); err != nil {
+	// Files staged locally, such as container snapshots, go in as well
+	if _, err := executeCommand("docker", ep.cliArgs("cp", stagingDir(outputDir, container)+"/.", helper+":/stage")...); err != nil {
+		return fmt.Errorf("failed to copy staged files: %w", err)
+	}
+
+	for i, volume := range volumes {
+		if !logVolume(i, volumes) {
+			continue
+		}
+		if err := backupVolume(ep, volume, stageVolume); err != nil {
 			return err
 		}
 	}
 
-	if err := createFinalArchive(container, tempDir, outputDir); err != nil {
+	if err := createFinalArchive(ep, container, stageVolume, outVolume); err != nil {
 		return err
 	}
 
-	return os.RemoveAll(filepath.Join(outputDir, "temp"))
+	finalArchivePath := filepath.Join(outputDir, container+".7z")
+	logSubStep("📥 Copying archive from %s", ep)
+	if _, err := executeCommand("docker", ep.cliArgs("cp", helper+":/out/"+container+".7z", finalArchivePath)...); err != nil {
+		return fmt.Errorf("failed to copy final archive: %w", err)
+	}
+
+	// Verify the file exists
+	if _, err := os.Stat(finalArchivePath); os.IsNotExist(err) {
+		return fmt.Errorf("final archive was not created at %s", finalArchivePath)
+	}
+	return nil
+}
+
+// logVolume logs a volume and reports whether it should be backed up
+func logVolume(i int, volumes []Volume) bool {
+	volume := volumes[i]
+	logHeader("🔸 Volume %d/%d:", i+1, len(volumes))
+	logSubStep("Source: %s", volume.Source)
+	logSubStep("Destination: %s", volume.Destination)
+	logSubStep("Type: %s", volume.Type)
+
+	if reason := volumeSkipReason(volume); reason != "" {
+		logSubStep("⏭️  Skipping %s", reason)
+		return false
+	}
+	return true
 }
 
-func pullLatestPackmateImage() error {
+// volumeSkipReason describes why a volume is not backed up, or returns an
+// empty string if it is
+func volumeSkipReason(volume Volume) string {
+	switch {
+	case volume.Type == "tmpfs":
+		return "tmpfs volume"
+	case volume.Source == "":
+		return "volume with empty source"
+	}
+	return ""
+}
+
+func pullLatestPackmateImage(ep *DockerEndpoint) error {
 	logSubStep("⬇️  Pulling the latest version of dublok/packmate...")
-	args := []string{"pull", "dublok/packmate:latest"}
-	_, err := executeCommand("docker", args...)
+	_, err := executeCommand("docker", ep.cliArgs("pull", packmateImage)...)
 	if err != nil {
 		return fmt.Errorf("failed to pull the latest Packmate image: %w", err)
 	}
@@ -57,45 +153,39 @@ func pullLatestPackmateImage() error {
 	return nil
 }
 
-func backupVolume(volume Volume, tempDir string) error {
+// backupVolume archives a volume into output, which is either a local
+// directory or a volume name on the engine
+func backupVolume(ep *DockerEndpoint, volume Volume, output string) error {
 	logSubStep("💾 Creating backup with Packmate...")
-	args := []string{
+	args := ep.cliArgs(
 		"run", "--rm",
-		"-v", volume.Source + ":/source:ro",
-		"-v", tempDir + ":/output",
-		"dublok/packmate:latest",
+		"-v", volume.Source+":/source:ro",
+		"-v", output+":/output",
+		packmateImage,
 		"--name", base64.StdEncoding.EncodeToString([]byte(volume.Source)),
 		"--compression=0",
 		"--method=copy",
 		"--multithreading=true",
 		"--extra=-ms=off",
-	}
-	logSubStep("⚙️  Executing: docker %v", args)
+	)
 	_, err := executeCommand("docker", args...)
 	return err
 }
 
-func createFinalArchive(container, tempDir, outputDir string) error {
-	finalArchivePath := filepath.Join(outputDir, container+".7z")
-	args := []string{
+// createFinalArchive packs the staged archives in source into
+// <container>.7z in output; both are directories or volume names
+func createFinalArchive(ep *DockerEndpoint, container, source, output string) error {
+	args := ep.cliArgs(
 		"run", "--rm",
-		"-v", tempDir + ":/source:ro",
-		"-v", filepath.Dir(finalArchivePath) + ":/output",
-		"dublok/packmate:latest",
+		"-v", source+":/source:ro",
+		"-v", output+":/output",
+		packmateImage,
 		"--name", container,
 		"--compression=0",
 		"--method=copy",
 		"--multithreading=true",
 		"--extra=-ms=off",
-	}
+	)
 	_, err := executeCommand("docker", args...)
-	if err != nil {
-		return err
-	}
-
-	// Verify the file exists
-	if _, err := os.Stat(finalArchivePath); os.IsNotExist(err) {
-		return fmt.Errorf("final archive was not created at %s", finalArchivePath)
-	}
-	return nil
+	return err
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	dropboxDeleteBatchPath      = "/2/files/delete_batch"
+	dropboxDeleteBatchCheckPath = "/2/files/delete_batch/check"
+	dropboxMoveBatchPath        = "/2/files/move_batch_v2"
+	dropboxMoveBatchCheckPath   = "/2/files/move_batch/check_v2"
+)
+
+// dropboxBatchLimit is the maximum number of entries of a batch job
+const dropboxBatchLimit = 1000
+
+// dropboxRelocation moves a file from one path to another
+type dropboxRelocation struct {
+	FromPath string `json:"from_path"`
+	ToPath   string `json:"to_path"`
+}
+
+// batchJobStatus is the response of a batch job or of its check endpoint
+type batchJobStatus struct {
+	Tag        string            `json:".tag"` // async_job_id, in_progress, complete or failed
+	AsyncJobID string            `json:"async_job_id"`
+	Entries    []json.RawMessage `json:"entries"`
+}
+
+// batchEntryResult is the outcome of a single entry of a batch job
+type batchEntryResult struct {
+	Tag     string          `json:".tag"` // success or failure
+	Failure json.RawMessage `json:"failure"`
+}
+
+// DeleteBatch deletes paths with delete_batch jobs of up to 1000 entries
+func (d *DropboxUploader) DeleteBatch(paths []string) error {
+	type deleteArg struct {
+		Path string `json:"path"`
+	}
+	for start := 0; start < len(paths); start += dropboxBatchLimit {
+		end := min(start+dropboxBatchLimit, len(paths))
+		var entries []deleteArg
+		for _, path := range paths[start:end] {
+			entries = append(entries, deleteArg{Path: path})
+		}
+
+		results, err := d.runBatchJob(dropboxDeleteBatchPath, dropboxDeleteBatchCheckPath, map[string]interface{}{
+			"entries": entries,
+		})
+		if err != nil {
+			return fmt.Errorf("delete batch failed: %v", err)
+		}
+		if err := batchFailures(results, paths[start:end]); err != nil {
+			return fmt.Errorf("delete batch failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// MoveBatch moves files with move_batch_v2 jobs of up to 1000 entries.
+// Missing parent folders of the destinations are created.
+func (d *DropboxUploader) MoveBatch(moves []dropboxRelocation) error {
+	for start := 0; start < len(moves); start += dropboxBatchLimit {
+		end := min(start+dropboxBatchLimit, len(moves))
+		var from []string
+		for _, move := range moves[start:end] {
+			from = append(from, move.FromPath)
+		}
+
+		results, err := d.runBatchJob(dropboxMoveBatchPath, dropboxMoveBatchCheckPath, map[string]interface{}{
+			"entries":    moves[start:end],
+			"autorename": true,
+		})
+		if err != nil {
+			return fmt.Errorf("move batch failed: %v", err)
+		}
+		if err := batchFailures(results, from); err != nil {
+			return fmt.Errorf("move batch failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// batchFailures turns the failed entries of a batch into an error
+func batchFailures(results []json.RawMessage, paths []string) error {
+	var failures []string
+	for i, raw := range results {
+		var result batchEntryResult
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return fmt.Errorf("error decoding batch result: %v", err)
+		}
+		if result.Tag != "success" && i < len(paths) {
+			failures = append(failures, fmt.Sprintf("%s: %s", paths[i], string(result.Failure)))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d entries failed: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// runBatchJob starts a batch job and polls its check endpoint until the
+// job completes, returning the results of its entries
+func (d *DropboxUploader) runBatchJob(startPath, checkPath string, requestBody interface{}) ([]json.RawMessage, error) {
+	status, err := d.batchRequest(startPath, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	wait := 500 * time.Millisecond
+	for status.Tag == "async_job_id" || status.Tag == "in_progress" {
+		jobID := status.AsyncJobID
+		time.Sleep(wait)
+		if wait < 10*time.Second {
+			wait *= 2
+		}
+		status, err = d.batchRequest(checkPath, map[string]string{"async_job_id": jobID})
+		if err != nil {
+			return nil, err
+		}
+		status.AsyncJobID = jobID
+	}
+
+	if status.Tag != "complete" {
+		return nil, fmt.Errorf("batch job ended with status %s", status.Tag)
+	}
+	return status.Entries, nil
+}
+
+func (d *DropboxUploader) batchRequest(endpoint string, requestBody interface{}) (*batchJobStatus, error) {
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %v", err)
+	}
+
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.API+endpoint, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var status batchJobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	return &status, nil
+}
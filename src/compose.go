@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Labels set by Docker Compose on the containers it creates
+const (
+	composeProjectLabel   = "com.docker.compose.project"
+	composeServiceLabel   = "com.docker.compose.service"
+	composeDependsOnLabel = "com.docker.compose.depends_on"
+)
+
+// ProjectService is a single container belonging to a compose project
+type ProjectService struct {
+	Container string
+	Service   string
+	DependsOn []string
+	Running   bool
+}
+
+// getProjectServices lists the containers of a compose project ordered so
+// that every service comes after the services it depends on
+func getProjectServices(ep *DockerEndpoint, project string) ([]ProjectService, error) {
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", composeProjectLabel+"="+project)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers of project %s: %v", project, err)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no containers found for compose project %s", project)
+	}
+
+	var services []ProjectService
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		services = append(services, ProjectService{
+			Container: strings.TrimPrefix(c.Names[0], "/"),
+			Service:   c.Labels[composeServiceLabel],
+			DependsOn: parseComposeDependsOn(c.Labels[composeDependsOnLabel]),
+			Running:   c.State == "running",
+		})
+	}
+
+	return orderProjectServices(services)
+}
+
+// parseComposeDependsOn extracts service names from the compose depends_on
+// label, whose entries look like "db:service_healthy:false"
+func parseComposeDependsOn(label string) []string {
+	var deps []string
+	for _, entry := range strings.Split(label, ",") {
+		name := strings.TrimSpace(strings.SplitN(entry, ":", 2)[0])
+		if name != "" {
+			deps = append(deps, name)
+		}
+	}
+	return deps
+}
+
+// orderProjectServices sorts services topologically, dependencies first.
+// Scaled services share a service name and are kept together.
+func orderProjectServices(services []ProjectService) ([]ProjectService, error) {
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Container < services[j].Container
+	})
+
+	byService := make(map[string][]ProjectService)
+	var names []string
+	for _, s := range services {
+		if _, ok := byService[s.Service]; !ok {
+			names = append(names, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], s)
+	}
+
+	var ordered []ProjectService
+	state := make(map[string]int) // 0 = unvisited, 1 = visiting, 2 = done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle involving service %s", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, s := range byService[name] {
+			for _, dep := range s.DependsOn {
+				// Dependencies on services outside the project are ignored
+				if _, ok := byService[dep]; !ok {
+					continue
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, byService[name]...)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// backupProject archives the volumes of every container in a compose
+// project into a single archive named after the project. When stop is
+// set, running services are stopped dependents-first and started again
+// dependencies-first, also when the backup fails. The configuration of
+// every container is captured and archived alongside the volumes.
+func backupProject(ep *DockerEndpoint, project string, stop bool, tempDir string, redactEnv bool) (snapshots []ContainerSnapshot, err error) {
+	services, err := getProjectServices(ep, project)
+	if err != nil {
+		return nil, err
+	}
+
+	logStep("🧩 Compose project %s has %d containers", project, len(services))
+	for _, s := range services {
+		logSubStep("%s (service: %s, running: %t)", s.Container, s.Service, s.Running)
+		snapshot, err := captureContainerSnapshot(ep, s.Container, redactEnv)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+	if err := writeSnapshots(snapshots, stagingDir(tempDir, project)); err != nil {
+		return nil, err
+	}
+
+	// Stop running services in reverse dependency order. Whatever was
+	// stopped is started again on every path, dependencies first.
+	var stopped []string
+	defer func() {
+		for i := len(stopped) - 1; i >= 0; i-- {
+			if startErr := startDockerContainer(ep, stopped[i]); startErr != nil && err == nil {
+				snapshots, err = nil, startErr
+			}
+		}
+	}()
+	if stop {
+		for i := len(services) - 1; i >= 0; i-- {
+			if !services[i].Running {
+				continue
+			}
+			if err := stopDockerContainer(ep, services[i].Container); err != nil {
+				return nil, err
+			}
+			stopped = append(stopped, services[i].Container)
+		}
+	}
+
+	// Collect volumes of all services, skipping mounts shared between them
+	var volumes []Volume
+	seen := make(map[string]bool)
+	for _, s := range services {
+		volumeResult, err := getContainerVolumes(ep, s.Container)
+		if err != nil {
+			return nil, err
+		}
+		if volumeResult.Status == "Failed" {
+			return nil, fmt.Errorf("failed to get volumes of %s: %s", s.Container, volumeResult.Error)
+		}
+		for _, volume := range volumeResult.Volumes {
+			if seen[volume.Source] {
+				continue
+			}
+			seen[volume.Source] = true
+			volumes = append(volumes, volume)
+		}
+	}
+
+	if err := processVolumes(ep, project, volumes, tempDir); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// contentHashBlockSize is the block size of the Dropbox content hash
+const contentHashBlockSize = 4 * 1024 * 1024
+
+// dropboxContentHash computes the Dropbox content hash of r: the SHA-256
+// of the concatenated SHA-256 digests of its 4 MB blocks
+func dropboxContentHash(r io.Reader) (string, error) {
+	overall := sha256.New()
+	block := make([]byte, contentHashBlockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			sum := sha256.Sum256(block[:n])
+			overall.Write(sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(overall.Sum(nil)), nil
+}
+
+// verifyContentHash compares the content hash of the uploaded file with
+// the one Dropbox computed for the stored copy
+func verifyContentHash(file *os.File, metadata *DropboxEntry) error {
+	logSubStep("🔍 Verifying content hash...")
+	hash, err := dropboxContentHash(io.NewSectionReader(file, 0, 1<<62))
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %v", file.Name(), err)
+	}
+	if metadata == nil || metadata.ContentHash == "" {
+		return fmt.Errorf("dropbox did not report a content hash for %s", file.Name())
+	}
+	if hash != metadata.ContentHash {
+		return fmt.Errorf("content hash mismatch for %s: local %s, Dropbox %s", metadata.Path, hash, metadata.ContentHash)
+	}
+	logSubStep("✅ Content hash matches: %s", hash)
+	return nil
+}
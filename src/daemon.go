@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata" // the runtime image ships without zoneinfo
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronParser accepts 5-field expressions, 6-field expressions with a
+// leading seconds field, and descriptors such as @daily or @every 6h
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// parseSchedule parses a cron expression. A CRON_TZ= or TZ= prefix in the
+// expression overrides the location.
+func parseSchedule(spec string, loc *time.Location) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.HasPrefix(spec, "CRON_TZ=") && !strings.HasPrefix(spec, "TZ=") {
+		spec = "CRON_TZ=" + loc.String() + " " + spec
+	}
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron schedule %q: %v", spec, err)
+	}
+	return schedule, nil
+}
+
+// runDaemon implements the daemon command, which keeps running and starts
+// a backup whenever a schedule fires. Entries may carry their own
+// schedule; the others follow the global one.
+func runDaemon(args []string) {
+	logHeader("=== Docker Volume Backup Service ===")
+
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	backup := registerBackupFlags(fs)
+	scheduleSpec := fs.String("schedule", os.Getenv("CRON_SCHEDULE"), "Default cron schedule (5 or 6 fields, or a descriptor such as @daily)")
+	catchUp := fs.Bool("catch-up", getEnvBool("CATCH_UP", false), "On startup, immediately back up entries whose last successful run is older than their schedule period")
+	timezone := fs.String("timezone", getEnvString("CRON_TIMEZONE", getEnvString("TZ", "UTC")), "Time zone schedules are evaluated in (e.g., Europe/Berlin)")
+	shutdownTimeout := longDurationFlag(fs, "shutdown-timeout", getEnvDuration("SHUTDOWN_TIMEOUT", 8*time.Second), "How long to wait for running backups to wind down on shutdown; keep it below the container's stop grace period")
+	fs.Parse(args)
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		logStep("❌ Invalid time zone %q: %v", *timezone, err)
+		os.Exit(1)
+	}
+
+	if *scheduleSpec != "" {
+		if _, err := parseSchedule(*scheduleSpec, loc); err != nil {
+			logStep("❌ %v", err)
+			os.Exit(1)
+		}
+		logStep("📝 Default schedule: %s (%s)", *scheduleSpec, loc)
+	}
+
+	if !backup.dropbox.valid() {
+		logStep("❌ Dropbox configuration is required")
+		os.Exit(1)
+	}
+
+	uploader, err := backup.newUploader()
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+
+	// Shutdown cancels the uploads in flight; stopped containers are
+	// started again regardless
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	uploader.Context = ctx
+
+	s := &scheduler{
+		backup:          backup,
+		uploader:        uploader,
+		defaultSpec:     *scheduleSpec,
+		loc:             loc,
+		locks:           newKeyLocks(),
+		parsed:          make(map[string]cron.Schedule),
+		catchUp:         *catchUp,
+		shutdownTimeout: *shutdownTimeout,
+	}
+	s.run(ctx)
+}
+
+// scheduler fires backup runs for the entries whose schedule is due
+type scheduler struct {
+	backup      *backupFlags
+	uploader    *DropboxUploader // shared by all runs so its access token is reused
+	defaultSpec string
+	loc         *time.Location
+	locks       *keyLocks
+	parsed      map[string]cron.Schedule
+	catchUp     bool
+	running     sync.WaitGroup
+
+	shutdownTimeout time.Duration // bounds the wait for running backups
+}
+
+// scheduledEntry is an entry together with the schedule it follows
+type scheduledEntry struct {
+	config   ContainerConfig
+	schedule cron.Schedule
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	// A dry run must not touch Dropbox, so interrupted uploads wait
+	if !*s.backup.dryRun {
+		s.backup.resumeUploads(s.uploader)
+	}
+
+	if s.catchUp {
+		s.runMissed(ctx)
+	}
+
+	for {
+		// Entries are reloaded before every wait so that discovered
+		// containers and their schedules stay current
+		endpoints, entries, err := s.loadEntries()
+		if err != nil {
+			logStep("❌ %v", err)
+			logStep("⏳ Retrying in 1m")
+			if !s.sleep(ctx, time.Minute) {
+				return
+			}
+			continue
+		}
+
+		now := time.Now()
+		next, due := nextDue(entries, now)
+		logScheduleMessage(now, next, s.loc)
+		var names []string
+		for _, config := range due {
+			names = append(names, configKey(config))
+		}
+		logSubStep("Entries: %s", strings.Join(names, ", "))
+
+		if !s.sleep(ctx, time.Until(next)) {
+			return
+		}
+
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.runBatch(ctx, due, endpoints)
+		}()
+	}
+}
+
+// loadEntries builds the entries of the next runs and resolves their
+// schedules
+func (s *scheduler) loadEntries() (DockerEndpoints, []scheduledEntry, error) {
+	endpoints, configs, err := s.backup.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := s.scheduleEntries(configs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return endpoints, entries, nil
+}
+
+// scheduleEntries pairs the entries with their effective schedule, the
+// default one unless they carry their own. Entries without a valid
+// schedule are skipped.
+func (s *scheduler) scheduleEntries(configs ContainerConfigs) ([]scheduledEntry, error) {
+	var entries []scheduledEntry
+	for _, config := range configs {
+		spec := config.Schedule
+		if spec == "" {
+			spec = s.defaultSpec
+		}
+		if spec == "" {
+			logSubStep("⚠️  Skipping %s: no schedule configured", configKey(config))
+			continue
+		}
+
+		schedule, ok := s.parsed[spec]
+		if !ok {
+			var err error
+			schedule, err = parseSchedule(spec, s.loc)
+			if err != nil {
+				logSubStep("⚠️  Skipping %s: %v", configKey(config), err)
+				continue
+			}
+			s.parsed[spec] = schedule
+		}
+		entries = append(entries, scheduledEntry{config: config, schedule: schedule})
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries with a valid schedule")
+	}
+	if err := validateSchedules(entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// validateSchedules reports dependencies between entries that do not fire
+// together. The daemon only runs entries whose schedule fired at the same
+// moment as one batch, so a dependency on another schedule could not be
+// honoured.
+func validateSchedules(entries []scheduledEntry) error {
+	index := make(map[string]int)
+	for i, entry := range entries {
+		index[configKey(entry.config)] = i
+	}
+
+	for _, entry := range entries {
+		for _, dep := range entry.config.DependsOn {
+			key := dependencyKey(entry.config, dep)
+			j, ok := index[key]
+			if !ok {
+				return fmt.Errorf("%s depends on %s, which has no valid schedule", configKey(entry.config), key)
+			}
+			if !sameSchedule(entry.schedule, entries[j].schedule) {
+				return fmt.Errorf("%s follows %s but depends on %s, which follows %s; entries linked by depends_on must share a schedule",
+					configKey(entry.config), describeSchedule(entry.config.Schedule), key, describeSchedule(entries[j].config.Schedule))
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleSamples is the number of fire times compared by sameSchedule
+const scheduleSamples = 500
+
+// sameSchedule reports whether two schedules fire at the same times, so
+// that e.g. "0 * * * *" and "@hourly" are equal. Fire times are compared
+// from an instant off the full minute, which also tells "@every 1h" apart
+// from "@hourly".
+func sameSchedule(a, b cron.Schedule) bool {
+	t := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for i := 0; i < scheduleSamples; i++ {
+		next := a.Next(t)
+		if !next.Equal(b.Next(t)) {
+			return false
+		}
+		if next.IsZero() {
+			return true
+		}
+		t = next
+	}
+	return true
+}
+
+// runMissed starts a run for entries whose last successful backup is older
+// than their schedule period, e.g. because the host was down when the
+// schedule fired
+func (s *scheduler) runMissed(ctx context.Context) {
+	endpoints, entries, err := s.loadEntries()
+	if err != nil {
+		logStep("❌ %v", err)
+		return
+	}
+
+	state := s.backup.store()
+	now := time.Now()
+	var missed ContainerConfigs
+	for _, entry := range entries {
+		key := configKey(entry.config)
+		last, ok := state.lastSuccess(key)
+		period := schedulePeriod(entry.schedule, now)
+		if !ok {
+			logSubStep("⏰ %s has no recorded successful run", key)
+			missed = append(missed, entry.config)
+		} else if now.Sub(last) > period {
+			logSubStep("⏰ %s last succeeded %s ago, schedule period is %s", key, formatDuration(now.Sub(last)), formatDuration(period))
+			missed = append(missed, entry.config)
+		}
+	}
+
+	if len(missed) == 0 {
+		logStep("✅ No missed runs to catch up")
+		return
+	}
+
+	logStep("⏰ Catching up %d missed entries", len(missed))
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.runBatch(ctx, missed, endpoints)
+	}()
+}
+
+// schedulePeriod returns the interval between the next two fire times
+func schedulePeriod(schedule cron.Schedule, now time.Time) time.Duration {
+	next := schedule.Next(now)
+	return schedule.Next(next).Sub(next)
+}
+
+// nextDue returns the earliest upcoming fire time and the entries due then.
+// Entries due at the same moment run together so that depends_on
+// ordering applies between them.
+func nextDue(entries []scheduledEntry, now time.Time) (time.Time, ContainerConfigs) {
+	var next time.Time
+	var due ContainerConfigs
+	for _, entry := range entries {
+		t := entry.schedule.Next(now)
+		switch {
+		case next.IsZero() || t.Before(next):
+			next = t
+			due = ContainerConfigs{entry.config}
+		case t.Equal(next):
+			due = append(due, entry.config)
+		}
+	}
+	return next, due
+}
+
+// sleep waits for d and reports false if the daemon is shutting down, in
+// which case it first gives running backups up to the shutdown timeout to
+// wind down
+func (s *scheduler) sleep(ctx context.Context, d time.Duration) bool {
+	if err := sleepContext(ctx, d); err == nil {
+		return true
+	}
+
+	logHeader("👋 Received shutdown signal")
+	logStep("⏳ Waiting up to %s for running backups to wind down...", formatDuration(s.shutdownTimeout))
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.shutdownTimeout):
+		logStep("⚠️  Backups still running after %s, exiting anyway", formatDuration(s.shutdownTimeout))
+	}
+	logHeader("👋 Exiting")
+	return false
+}
+
+// runBatch runs a backup of the due entries. Entries and their
+// dependencies are locked first, so runs sharing a container are
+// serialised while unrelated runs proceed in parallel.
+func (s *scheduler) runBatch(ctx context.Context, configs ContainerConfigs, endpoints DockerEndpoints) {
+	var keys []string
+	for _, config := range configs {
+		keys = append(keys, configKey(config))
+		for _, dep := range config.DependsOn {
+			keys = append(keys, dependencyKey(config, dep))
+		}
+	}
+	unlock := s.locks.lock(keys)
+	defer unlock()
+
+	logHeader("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	logHeader("🔄 Backup Process Started (%d entries)", len(configs))
+	started := time.Now()
+
+	if err := processContainers(ctx, configs, s.uploader, s.backup.runOptions(endpoints)); err != nil {
+		logStep("❌ Failed to process containers: %v", err)
+		logHeader("❌ Backup Process Failed after %s", formatDuration(time.Since(started)))
+	} else {
+		logHeader("✅ Backup Process Completed in %s", formatDuration(time.Since(started)))
+	}
+	logHeader("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+}
+
+// keyLocks hands out one mutex per key
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// lock acquires the mutexes of all keys and returns a function releasing
+// them. Keys are locked in sorted order so that overlapping sets cannot
+// deadlock.
+func (k *keyLocks) lock(keys []string) func() {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	var held []*sync.Mutex
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		k.mu.Lock()
+		m, ok := k.locks[key]
+		if !ok {
+			m = &sync.Mutex{}
+			k.locks[key] = m
+		}
+		k.mu.Unlock()
+
+		m.Lock()
+		held = append(held, m)
+	}
+
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+func logScheduleMessage(now, next time.Time, loc *time.Location) {
+	logStep("🕐 Current time: %s", now.In(loc).Format("2006-01-02 15:04:05 MST"))
+	logStep("⏳ Next backup in %s at %s", formatDuration(next.Sub(now)), next.In(loc).Format("2006-01-02 15:04:05 MST"))
+}
+
+// formatDuration renders a duration as e.g. "1d 2h 3m 4s"
+func formatDuration(d time.Duration) string {
+	seconds := int64(d.Round(time.Second) / time.Second)
+	days := seconds / 86400
+	hours := (seconds % 86400) / 3600
+	minutes := (seconds % 3600) / 60
+	seconds = seconds % 60
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	if seconds > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", seconds))
+	}
+	return strings.Join(parts, " ")
+}
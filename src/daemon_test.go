@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestScheduleEntries(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultSpec string
+		configs     ContainerConfigs
+		wantErr     string
+	}{
+		{
+			name:        "default schedule",
+			defaultSpec: "0 3 * * *",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "0 3 * * *"},
+				{Container: "app", DependsOn: []string{"db"}},
+			},
+		},
+		{
+			name: "equivalent expressions",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "0 * * * *"},
+				{Container: "app", Schedule: "@hourly", DependsOn: []string{"db"}},
+			},
+		},
+		{
+			name: "different schedules",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "@hourly"},
+				{Container: "app", Schedule: "@daily", DependsOn: []string{"db"}},
+			},
+			wantErr: "must share a schedule",
+		},
+		{
+			name: "interval and hourly",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "@every 1h"},
+				{Container: "app", Schedule: "@hourly", DependsOn: []string{"db"}},
+			},
+			wantErr: "must share a schedule",
+		},
+		{
+			name: "unscheduled dependency",
+			configs: ContainerConfigs{
+				{Container: "db"},
+				{Container: "app", Schedule: "@hourly", DependsOn: []string{"db"}},
+			},
+			wantErr: "no valid schedule",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &scheduler{defaultSpec: tt.defaultSpec, loc: time.UTC, parsed: make(map[string]cron.Schedule)}
+			_, err := s.scheduleEntries(tt.configs)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("scheduleEntries() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("scheduleEntries() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// runDAG calls fn for every entry once all of its dependencies have
+// completed, running up to concurrency entries at the same time. Entries
+// become ready in configuration order. After the first failure no new
+// entries are started; the ones already running are waited for.
+// Dependencies that are not part of configs are ignored.
+func runDAG(configs ContainerConfigs, concurrency int, fn func(config ContainerConfig) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	index := make(map[string]int)
+	for i, config := range configs {
+		if _, ok := index[configKey(config)]; !ok {
+			index[configKey(config)] = i
+		}
+	}
+
+	// Count unfinished dependencies and record reverse edges
+	pending := make([]int, len(configs))
+	dependents := make([][]int, len(configs))
+	for i, config := range configs {
+		seen := make(map[int]bool)
+		for _, dep := range config.DependsOn {
+			j, ok := index[dependencyKey(config, dep)]
+			if !ok || j == i || seen[j] {
+				continue
+			}
+			seen[j] = true
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range configs {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result)
+	running := 0
+	completed := 0
+	var firstErr error
+
+	for {
+		// Start ready entries while there is capacity
+		for firstErr == nil && running < concurrency && len(ready) > 0 {
+			sort.Ints(ready)
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func(i int) {
+				results <- result{index: i, err: fn(configs[i])}
+			}(i)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", configKey(configs[r.index]), r.err)
+			}
+			continue
+		}
+
+		completed++
+		for _, d := range dependents[r.index] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// Entries still waiting on dependencies can only be part of a cycle
+	if completed < len(configs) {
+		var stuck []string
+		for i, config := range configs {
+			if pending[i] > 0 {
+				stuck = append(stuck, configKey(config))
+			}
+		}
+		return fmt.Errorf("dependency cycle among: %s", strings.Join(stuck, ", "))
+	}
+
+	return nil
+}
+
+// validateDependencies reports duplicate entries, dependencies on entries
+// that are not configured, and dependency cycles. Schedules are checked by
+// the daemon, see validateSchedules.
+func validateDependencies(configs ContainerConfigs) error {
+	index := make(map[string]int)
+	for i, config := range configs {
+		key := configKey(config)
+		if _, ok := index[key]; ok {
+			return fmt.Errorf("duplicate entry: %s", key)
+		}
+		index[key] = i
+	}
+
+	for _, config := range configs {
+		for _, dep := range config.DependsOn {
+			if _, ok := index[dependencyKey(config, dep)]; !ok {
+				return fmt.Errorf("%s depends on unknown entry %s", configKey(config), dependencyKey(config, dep))
+			}
+		}
+	}
+
+	// Depth-first search; reaching an entry that is still on the path
+	// closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(configs))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		key := configKey(configs[i])
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			for j, p := range path {
+				if p == key {
+					return fmt.Errorf("dependency cycle: %s", strings.Join(append(path[j:], key), " -> "))
+				}
+			}
+		}
+
+		state[i] = visiting
+		path = append(path, key)
+		for _, dep := range configs[i].DependsOn {
+			if err := visit(index[dependencyKey(configs[i], dep)]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		return nil
+	}
+
+	for i := range configs {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeSchedule names an entry schedule in messages
+func describeSchedule(spec string) string {
+	if strings.TrimSpace(spec) == "" {
+		return "the default schedule"
+	}
+	return fmt.Sprintf("schedule %q", spec)
+}
+
+// topologicalOrder returns the entries with every entry after its
+// dependencies, otherwise keeping configuration order. Dependencies that
+// are not part of configs are ignored; configs must not contain cycles.
+func topologicalOrder(configs ContainerConfigs) ContainerConfigs {
+	index := make(map[string]int)
+	for i, config := range configs {
+		index[configKey(config)] = i
+	}
+
+	var ordered ContainerConfigs
+	added := make([]bool, len(configs))
+	var add func(i int)
+	add = func(i int) {
+		if added[i] {
+			return
+		}
+		added[i] = true
+		for _, dep := range configs[i].DependsOn {
+			if j, ok := index[dependencyKey(configs[i], dep)]; ok {
+				add(j)
+			}
+		}
+		ordered = append(ordered, configs[i])
+	}
+
+	for i := range configs {
+		add(i)
+	}
+	return ordered
+}
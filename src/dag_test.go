@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs ContainerConfigs
+		wantErr string
+	}{
+		{
+			name: "valid chain",
+			configs: ContainerConfigs{
+				{Container: "db"},
+				{Container: "app", DependsOn: []string{"db"}},
+			},
+		},
+		{
+			name: "shared schedule",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "@hourly"},
+				{Container: "app", Schedule: "@hourly", DependsOn: []string{"db"}},
+			},
+		},
+		{
+			name:    "duplicate entry",
+			configs: ContainerConfigs{{Container: "db"}, {Container: "db"}},
+			wantErr: "duplicate entry",
+		},
+		{
+			name:    "unknown dependency",
+			configs: ContainerConfigs{{Container: "app", DependsOn: []string{"db"}}},
+			wantErr: "unknown entry",
+		},
+		{
+			// Only the daemon needs linked entries to fire together
+			name: "different schedules",
+			configs: ContainerConfigs{
+				{Container: "db", Schedule: "@hourly"},
+				{Container: "app", DependsOn: []string{"db"}},
+			},
+		},
+		{
+			name: "cycle",
+			configs: ContainerConfigs{
+				{Container: "a", DependsOn: []string{"b"}},
+				{Container: "b", DependsOn: []string{"a"}},
+			},
+			wantErr: "dependency cycle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDependencies(tt.configs)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("validateDependencies() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("validateDependencies() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Labels read from containers during auto-discovery
+const (
+	labelEnable    = "volback.enable"
+	labelBackupID  = "volback.backup_id"
+	labelStop      = "volback.stop"
+	labelDependsOn = "volback.depends_on"
+	labelSchedule  = "volback.schedule"
+)
+
+// Retention labels, e.g. volback.keep_daily=30 or volback.keep_within=30d.
+// Any of them gives the container its own retention policy.
+const (
+	labelKeepLast    = "volback.keep_last"
+	labelKeepHourly  = "volback.keep_hourly"
+	labelKeepDaily   = "volback.keep_daily"
+	labelKeepWeekly  = "volback.keep_weekly"
+	labelKeepMonthly = "volback.keep_monthly"
+	labelKeepYearly  = "volback.keep_yearly"
+	labelKeepWithin  = "volback.keep_within"
+	labelMinAge      = "volback.min_age"
+)
+
+// discoverContainerConfigs builds container configurations from the labels
+// of all containers that have volback.enable=true
+func discoverContainerConfigs(ep *DockerEndpoint) (ContainerConfigs, error) {
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelEnable+"=true")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+
+	var configs ContainerConfigs
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		config, err := containerConfigFromLabels(strings.TrimPrefix(c.Names[0], "/"), c.Labels)
+		if err != nil {
+			logSubStep("⚠️  Skipping container %s: %v", c.Names[0], err)
+			continue
+		}
+		config.Endpoint = ep.Name
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+func containerConfigFromLabels(name string, labels map[string]string) (ContainerConfig, error) {
+	config := ContainerConfig{Container: name}
+
+	if backupID := strings.TrimSpace(labels[labelBackupID]); backupID != "" {
+		config.BackupID = &backupID
+	}
+
+	if value, ok := labels[labelStop]; ok {
+		stop, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return config, fmt.Errorf("invalid %s label %q", labelStop, value)
+		}
+		config.Stop = &stop
+	}
+
+	config.DependsOn = splitList(labels[labelDependsOn])
+	config.Schedule = strings.TrimSpace(labels[labelSchedule])
+
+	retention, err := retentionFromLabels(labels)
+	if err != nil {
+		return config, err
+	}
+	config.Retention = retention
+
+	return config, nil
+}
+
+// retentionFromLabels builds the retention policy set by the labels of a
+// container, or returns nil if none is set
+func retentionFromLabels(labels map[string]string) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	counts := map[string]*int{
+		labelKeepLast:    &policy.KeepLast,
+		labelKeepHourly:  &policy.KeepHourly,
+		labelKeepDaily:   &policy.KeepDaily,
+		labelKeepWeekly:  &policy.KeepWeekly,
+		labelKeepMonthly: &policy.KeepMonthly,
+		labelKeepYearly:  &policy.KeepYearly,
+	}
+	durations := map[string]*time.Duration{
+		labelKeepWithin: &policy.KeepWithin,
+		labelMinAge:     &policy.MinAge,
+	}
+
+	found := false
+	for label, count := range counts {
+		if value, ok := labels[label]; ok {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s label %q", label, value)
+			}
+			*count = n
+			found = true
+		}
+	}
+	for label, duration := range durations {
+		if value, ok := labels[label]; ok {
+			d, err := parseLongDuration(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s label %q", label, value)
+			}
+			*duration = d
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
+// mergeContainerConfigs appends discovered configurations to the explicit
+// ones. Explicit entries win when both name the same container.
+func mergeContainerConfigs(explicit, discovered ContainerConfigs) ContainerConfigs {
+	merged := append(ContainerConfigs{}, explicit...)
+
+	known := make(map[string]bool)
+	for _, config := range explicit {
+		known[configKey(config)] = true
+	}
+
+	for _, config := range discovered {
+		if known[configKey(config)] {
+			logSubStep("ℹ️  Using explicit configuration for discovered container: %s", config.Container)
+			continue
+		}
+		known[configKey(config)] = true
+		merged = append(merged, config)
+	}
+
+	return merged
+}
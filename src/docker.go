@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 )
 
 func executeCommand(cmdPath string, args ...string) ([]byte, error) {
@@ -21,9 +20,9 @@ func executeCommand(cmdPath string, args ...string) ([]byte, error) {
 	return output, nil
 }
 
-func getContainerVolumes(containerName string) (*VolumeResult, error) {
+func getContainerVolumes(ep *DockerEndpoint, containerName string) (*VolumeResult, error) {
 	// Initialize Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := ep.newClient()
 	if err != nil {
 		return nil, fmt.Errorf("error initializing Docker client: %v", err)
 	}
@@ -61,9 +60,9 @@ func getContainerVolumes(containerName string) (*VolumeResult, error) {
 	return volumeInfo, nil
 }
 
-func processContainer(containerName string, action string) (*ControlResult, error) {
+func processContainer(ep *DockerEndpoint, containerName string, action string) (*ControlResult, error) {
 	// Initialize Docker client
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := ep.newClient()
 	if err != nil {
 		return nil, fmt.Errorf("error initializing Docker client: %v", err)
 	}
@@ -102,18 +101,24 @@ func processContainer(containerName string, action string) (*ControlResult, erro
 	return result, nil
 }
 
-func stopDockerContainer(containerName string) error {
-	result, err := processContainer(containerName, "stop")
-	if err != nil || result.Status == "Failed" {
+func stopDockerContainer(ep *DockerEndpoint, containerName string) error {
+	result, err := processContainer(ep, containerName, "stop")
+	if err == nil && result.Status == "Failed" {
+		err = fmt.Errorf("failed to stop container %s: %s", containerName, result.Error)
+	}
+	if err != nil {
 		logStep("❌ Failed to stop container: %v", err)
 		return err
 	}
 	return nil
 }
 
-func startDockerContainer(containerName string) error {
-	result, err := processContainer(containerName, "start")
-	if err != nil || result.Status == "Failed" {
+func startDockerContainer(ep *DockerEndpoint, containerName string) error {
+	result, err := processContainer(ep, containerName, "start")
+	if err == nil && result.Status == "Failed" {
+		err = fmt.Errorf("failed to start container %s: %s", containerName, result.Error)
+	}
+	if err != nil {
 		logStep("❌ Failed to start container: %v", err)
 		return err
 	}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,46 +12,127 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
+// DropboxURLs are the base URLs of the Dropbox API. They can be pointed at
+// a stand-in server, e.g. in tests.
+type DropboxURLs struct {
+	API     string // RPC endpoints
+	Content string // upload and download endpoints
+	Token   string // OAuth2 token endpoint
+}
+
+// defaultDropboxURLs are the production endpoints
+var defaultDropboxURLs = DropboxURLs{
+	API:     "https://api.dropboxapi.com",
+	Content: "https://content.dropboxapi.com",
+	Token:   "https://api.dropbox.com/oauth2/token",
+}
+
+// Endpoint paths, relative to the API or content base URL
 const (
-	dropboxUploadURL     = "https://content.dropboxapi.com/2/files/upload"
-	dropboxTokenURL      = "https://api.dropbox.com/oauth2/token"
-	dropboxListFolderURL = "https://api.dropboxapi.com/2/files/list_folder"
-	dropboxDeleteFileURL = "https://api.dropboxapi.com/2/files/delete_v2"
+	dropboxUploadPath             = "/2/files/upload"
+	dropboxDownloadPath           = "/2/files/download"
+	dropboxListFolderPath         = "/2/files/list_folder"
+	dropboxListFolderContinuePath = "/2/files/list_folder/continue"
+	dropboxDeleteFilePath         = "/2/files/delete_v2"
+	dropboxSessionStartPath       = "/2/files/upload_session/start"
+	dropboxSessionAppendPath      = "/2/files/upload_session/append_v2"
+	dropboxSessionFinishPath      = "/2/files/upload_session/finish"
+	dropboxSessionFinishBatchPath = "/2/files/upload_session/finish_batch_v2"
 )
 
+// Upload session chunks must be a multiple of 4 MB, except the last one,
+// and requests may not exceed 150 MB, so chunks are at most 148 MB
+const (
+	dropboxChunkUnit    = 4 * 1024 * 1024
+	dropboxMaxChunkSize = 148 * 1024 * 1024
+)
+
+// validateChunkSize checks an upload chunk size against the session limits
+func validateChunkSize(size int64) error {
+	if size <= 0 || size > dropboxMaxChunkSize || size%dropboxChunkUnit != 0 {
+		return fmt.Errorf("upload chunk size must be a multiple of 4 MB and at most 148 MB, got %.2f MB", float64(size)/1024/1024)
+	}
+	return nil
+}
+
+// errDropboxConflict is returned by CreateFile when the path already exists,
+// and by a conditional delete when the file has changed
+var errDropboxConflict = errors.New("file already exists")
+
+// errDropboxNotFound is returned by ListFolder when the folder does not exist
+var errDropboxNotFound = errors.New("folder not found")
+
 type DropboxUploader struct {
 	RefreshToken string
 	ClientID     string
 	ClientSecret string
 	accessToken  string
 	tokenExpiry  time.Time
+	tokenMu      sync.Mutex // scheduled runs may share an uploader
+	ChunkSize    int64      // size of upload session chunks in bytes
+	Parallelism  int        // number of chunks uploaded at the same time
+	Limiter      *bandwidthLimiter
+	State        *stateStore // persists upload sessions for resuming
+	SelectUser   string      // team member whose space is used (Dropbox-API-Select-User)
+	PathRoot     string      // namespace paths are relative to (Dropbox-API-Path-Root)
+	URLs         DropboxURLs
+	HTTPClient   *http.Client    // sends requests; authentication is added on top
+	Context      context.Context // cancels uploads when done, e.g. on daemon shutdown
+	client       *http.Client
+
+	entriesMu sync.Mutex
+	entries   map[string]pendingEntry // entries of the uploads in progress, by target path
 }
 
 type DropboxAPIArg struct {
-	Path           string `json:"path"`
-	Mode           string `json:"mode"`
-	AutoRename     bool   `json:"autorename"`
-	Mute           bool   `json:"mute"`
-	StrictConflict bool   `json:"strict_conflict"`
+	Path           string      `json:"path"`
+	Mode           interface{} `json:"mode"` // "add", "overwrite" or a dropboxUpdateMode
+	AutoRename     bool        `json:"autorename"`
+	Mute           bool        `json:"mute"`
+	StrictConflict bool        `json:"strict_conflict"`
+}
+
+// dropboxUpdateMode is the write mode that replaces a file only while it
+// is still at the given revision
+type dropboxUpdateMode struct {
+	Tag    string `json:".tag"` // always "update"
+	Update string `json:"update"`
 }
 
 type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int    `json:"expires_in"`
-	TokenType   string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"` // only returned for authorization codes
+}
+
+// DropboxEntry is the metadata of a file or folder returned by a listing
+type DropboxEntry struct {
+	Tag            string    `json:".tag"` // file, folder or deleted
+	Name           string    `json:"name"`
+	Path           string    `json:"path_display"`
+	PathLower      string    `json:"path_lower"`
+	ID             string    `json:"id"`
+	Rev            string    `json:"rev"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+	ContentHash    string    `json:"content_hash"`
+}
+
+// IsFile reports whether the entry is a file
+func (e DropboxEntry) IsFile() bool {
+	return e.Tag == "file"
 }
 
 // Dropbox API response structures
 type DropboxListFolderResponse struct {
-	Entries []struct {
-		Name string `json:".tag"`
-		Path string `json:"path_display"`
-	} `json:"entries"`
-	HasMore bool   `json:"has_more"`
-	Cursor  string `json:"cursor"`
+	Entries []DropboxEntry `json:"entries"`
+	HasMore bool           `json:"has_more"`
+	Cursor  string         `json:"cursor"`
 }
 
 type DropboxDeleteFileResponse struct {
@@ -58,36 +141,74 @@ type DropboxDeleteFileResponse struct {
 	} `json:"metadata"`
 }
 
-// ListFiles returns a list of files in the specified Dropbox path
-func (d *DropboxUploader) ListFiles(path string) ([]string, error) {
+// ListFilesWithSuffix returns a list of files in the specified Dropbox path
+// whose names end in suffix
+func (d *DropboxUploader) ListFilesWithSuffix(path, suffix string) ([]string, error) {
+	entries, err := d.ListFolder(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract file paths
+	var files []string
+	for _, entry := range entries {
+		if entry.IsFile() && strings.HasSuffix(entry.Path, suffix) {
+			files = append(files, entry.Path)
+		}
+	}
+
+	return files, nil
+}
+
+// ListFolder returns all entries of a Dropbox folder, following the
+// listing cursor until every page has been read. With recursive set the
+// entries of subfolders are included.
+func (d *DropboxUploader) ListFolder(path string, recursive bool) ([]DropboxEntry, error) {
 	// Ensure path starts with "/"
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
 
-	// Prepare request body
-	requestBody := map[string]interface{}{
+	page, err := d.listFolderPage(d.URLs.API+dropboxListFolderPath, map[string]interface{}{
 		"path":      path,
-		"recursive": false,
+		"recursive": recursive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := page.Entries
+
+	for page.HasMore {
+		page, err = d.listFolderPage(d.URLs.API+dropboxListFolderContinuePath, map[string]interface{}{
+			"cursor": page.Cursor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page.Entries...)
 	}
 
+	return entries, nil
+}
+
+// listFolderPage requests one page of a folder listing
+func (d *DropboxUploader) listFolderPage(endpoint string, requestBody map[string]interface{}) (*DropboxListFolderResponse, error) {
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling request body: %v", err)
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", dropboxListFolderURL, strings.NewReader(string(jsonBody)))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
 
-	// Make request
-	resp, err := http.DefaultClient.Do(req)
+		// Set headers
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error making request: %v", err)
 	}
@@ -95,7 +216,11 @@ func (d *DropboxUploader) ListFiles(path string) ([]string, error) {
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusConflict && strings.Contains(string(body), "not_found") {
+			return nil, errDropboxNotFound
+		}
+		return nil, fmt.Errorf("listing failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Parse response
@@ -103,20 +228,18 @@ func (d *DropboxUploader) ListFiles(path string) ([]string, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&listResponse); err != nil {
 		return nil, fmt.Errorf("error decoding response: %v", err)
 	}
-
-	// Extract file paths
-	var files []string
-	for _, entry := range listResponse.Entries {
-		if strings.HasSuffix(entry.Path, ".7z") {
-			files = append(files, entry.Path)
-		}
-	}
-
-	return files, nil
+	return &listResponse, nil
 }
 
 // DeleteFile deletes a file from Dropbox
 func (d *DropboxUploader) DeleteFile(path string) error {
+	return d.deleteFile(path, "")
+}
+
+// deleteFile deletes a file from Dropbox. A non-empty rev makes the delete
+// conditional: it fails with errDropboxConflict unless rev is still the
+// latest revision of the file.
+func (d *DropboxUploader) deleteFile(path, rev string) error {
 	// Ensure path starts with "/"
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
@@ -126,6 +249,9 @@ func (d *DropboxUploader) DeleteFile(path string) error {
 	requestBody := map[string]interface{}{
 		"path": path,
 	}
+	if rev != "" {
+		requestBody["parent_rev"] = rev
+	}
 
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
@@ -133,23 +259,25 @@ func (d *DropboxUploader) DeleteFile(path string) error {
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", dropboxDeleteFileURL, strings.NewReader(string(jsonBody)))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.API+dropboxDeleteFilePath, strings.NewReader(string(jsonBody)))
+		if err != nil {
+			return nil, err
+		}
 
-	// Make request
-	resp, err := http.DefaultClient.Do(req)
+		// Set headers
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("error making request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Check response status
+	if resp.StatusCode == http.StatusConflict && rev != "" {
+		return errDropboxConflict
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -164,12 +292,75 @@ func (d *DropboxUploader) DeleteFile(path string) error {
 	return nil
 }
 
+// Download writes the contents of a Dropbox file to w
+func (d *DropboxUploader) Download(path string, w io.Writer) error {
+	_, err := d.download(path, w)
+	return err
+}
+
+// download writes the contents of a Dropbox file to w and returns the
+// metadata of the revision that was read
+func (d *DropboxUploader) download(path string, w io.Writer) (*DropboxEntry, error) {
+	if err := d.ensureValidToken(); err != nil {
+		return nil, fmt.Errorf("failed to ensure valid token: %v", err)
+	}
+
+	// Ensure path starts with "/"
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	apiArgJSON, err := json.Marshal(map[string]string{"path": path})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling API argument: %v", err)
+	}
+
+	// Create request
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxDownloadPath, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Set headers
+		req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("download failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	entry := &DropboxEntry{Tag: "file"}
+	if result := resp.Header.Get("Dropbox-API-Result"); result != "" {
+		if err := json.Unmarshal([]byte(result), entry); err != nil {
+			return nil, fmt.Errorf("error decoding download metadata: %v", err)
+		}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return nil, fmt.Errorf("error reading download: %v", err)
+	}
+	return entry, nil
+}
+
 func NewDropboxUploader(refreshToken, clientID, clientSecret string) *DropboxUploader {
-	return &DropboxUploader{
+	d := &DropboxUploader{
 		RefreshToken: refreshToken,
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
+		ChunkSize:    dropboxMaxChunkSize,
+		Parallelism:  1,
+		URLs:         defaultDropboxURLs,
+		HTTPClient:   http.DefaultClient,
 	}
+	d.client = &http.Client{Transport: &authTransport{uploader: d}}
+	return d
 }
 
 func (d *DropboxUploader) refreshAccessToken() error {
@@ -180,102 +371,149 @@ func (d *DropboxUploader) refreshAccessToken() error {
 	formData.Set("client_id", d.ClientID)
 	formData.Set("client_secret", d.ClientSecret)
 
-	// Create request
-	req, err := http.NewRequest("POST", dropboxTokenURL, strings.NewReader(formData.Encode()))
+	tokenResp, err := requestToken(d.HTTPClient, d.URLs.Token, formData)
 	if err != nil {
-		return fmt.Errorf("failed to create token request: %v", err)
+		return err
 	}
 
-	// Set correct content type for form data
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	d.accessToken = tokenResp.AccessToken
+	d.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	return nil
+}
+
+// requestToken posts a grant to the OAuth2 token endpoint
+func requestToken(client *http.Client, tokenURL string, formData url.Values) (*TokenResponse, error) {
+	// Create request
+	resp, err := sendWithRetry(context.Background(), client, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", tokenURL, strings.NewReader(formData.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		// Set correct content type for form data
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to execute token request: %v", err)
+		return nil, fmt.Errorf("failed to execute token request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("token refresh failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var tokenResp TokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return fmt.Errorf("failed to decode token response: %v", err)
+		return nil, fmt.Errorf("failed to decode token response: %v", err)
 	}
-
-	d.accessToken = tokenResp.AccessToken
-	d.tokenExpiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-
-	return nil
+	return &tokenResp, nil
 }
 
+// ensureValidToken checks the credentials up front; requests refresh the
+// token themselves as needed
 func (d *DropboxUploader) ensureValidToken() error {
-	if d.accessToken == "" || time.Now().After(d.tokenExpiry) {
-		return d.refreshAccessToken()
-	}
-	return nil
+	_, err := d.token()
+	return err
 }
 
-// Upload uploads a local file to Dropbox
+// Upload uploads a local file to Dropbox and returns the metadata of the
+// stored file. The content hash Dropbox reports must match the local file.
 // sourcePath: local file path
 // targetPath: destination path in Dropbox (should start with "/")
-func (d *DropboxUploader) Upload(sourcePath, targetPath string) error {
-	const CHUNK_SIZE = 150 * 1024 * 1024 // 150MB chunks
+func (d *DropboxUploader) Upload(sourcePath, targetPath string) (*DropboxEntry, error) {
+	chunkSize := d.ChunkSize
+	if err := validateChunkSize(chunkSize); err != nil {
+		return nil, err
+	}
 
 	// Open and stat the source file
 	file, err := os.Open(sourcePath)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %v", err)
+		return nil, fmt.Errorf("failed to open source file: %v", err)
 	}
 	defer file.Close()
 
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %v", err)
+		return nil, fmt.Errorf("failed to stat file: %v", err)
 	}
 	fileSize := fileInfo.Size()
 
 	if err := d.ensureValidToken(); err != nil {
-		return fmt.Errorf("failed to ensure valid token: %v", err)
+		return nil, fmt.Errorf("failed to ensure valid token: %v", err)
 	}
 
 	logStep("📁 Starting upload for: %s", filepath.Base(sourcePath))
 	logSubStep("Target path: %s", targetPath)
 	logSubStep("File size: %.2f MB", float64(fileSize)/1024/1024)
 
-	// For files larger than CHUNK_SIZE, use upload session
-	if fileSize > CHUNK_SIZE {
+	var metadata *DropboxEntry
+	switch {
+	// For files larger than a chunk, use upload session
+	case fileSize > chunkSize && d.Parallelism > 1:
+		logStep("📦 Large file detected - using parallel chunked upload")
+		metadata, err = d.uploadLargeFileConcurrent(file, targetPath, fileSize, chunkSize)
+	case fileSize > chunkSize:
 		logStep("📦 Large file detected - using chunked upload")
-		return d.uploadLargeFile(file, targetPath, fileSize, CHUNK_SIZE)
+		metadata, err = d.uploadLargeFile(file, targetPath, fileSize, chunkSize)
+	// For smaller files, use simple upload
+	default:
+		logStep("📦 Small file detected - using simple upload")
+		metadata, err = d.uploadSmallFile(file, targetPath)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// For smaller files, use simple upload
-	logStep("📦 Small file detected - using simple upload")
-	return d.uploadSmallFile(file, targetPath)
+	if err := verifyContentHash(file, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
 }
 
-func (d *DropboxUploader) uploadLargeFile(file *os.File, targetPath string, fileSize int64, chunkSize int64) error {
+// uploadLargeFile uploads a file through an upload session, one chunk
+// after another. An interrupted session for the same file is resumed.
+func (d *DropboxUploader) uploadLargeFile(file *os.File, targetPath string, fileSize int64, chunkSize int64) (*DropboxEntry, error) {
 	logStep("📦 Starting chunked upload process...")
 	logSubStep("Total file size: %.2f MB", float64(fileSize)/1024/1024)
 	logSubStep("Chunk size: %.2f MB", float64(chunkSize)/1024/1024)
 	totalChunks := (fileSize + chunkSize - 1) / chunkSize
 	logSubStep("Total chunks: %d", totalChunks)
 
-	// Start upload session
-	logStep("📤 Uploading first chunk...")
-	sessionID, err := d.startUploadSession(file, chunkSize)
-	if err != nil {
-		return fmt.Errorf("failed to start upload session: %v", err)
+	var sessionID string
+	var offset int64
+	resumed := false
+	if pending := d.pendingUpload(file, targetPath, chunkSize, false); pending != nil {
+		sessionID, offset, resumed = pending.SessionID, pending.Offset, true
+		logStep("🔁 Resuming upload session at %.2f MB", float64(offset)/1024/1024)
+		logSubStep("Session ID: %s", sessionID)
+	} else {
+		// Start upload session
+		logStep("📤 Uploading first chunk...")
+		id, err := d.startUploadSession(file, chunkSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start upload session: %v", err)
+		}
+		logSubStep("✅ First chunk uploaded successfully")
+		logSubStep("Session ID: %s", id)
+
+		sessionID = id
+		offset = chunkSize
+		if offset > fileSize {
+			offset = fileSize
+		}
+		pending, err := newPendingUpload(file, sessionID, chunkSize, false)
+		if err != nil {
+			return nil, err
+		}
+		pending.Offset = offset
+		d.startPendingUpload(targetPath, pending)
 	}
-	logSubStep("✅ First chunk uploaded successfully")
-	logSubStep("Session ID: %s", sessionID)
 
 	// Upload chunks
-	offset := chunkSize
-	currentChunk := 2 // Starting from 2 as we've already uploaded the first chunk
 	for offset < fileSize {
 		remaining := fileSize - offset
 		currentChunkSize := chunkSize
@@ -283,49 +521,295 @@ func (d *DropboxUploader) uploadLargeFile(file *os.File, targetPath string, file
 			currentChunkSize = remaining
 		}
 
-		logStep("📤 Uploading chunk %d/%d...", currentChunk, totalChunks)
+		logStep("📤 Uploading chunk %d/%d...", offset/chunkSize+1, totalChunks)
 		logSubStep("Offset: %.2f MB", float64(offset)/1024/1024)
 		logSubStep("Chunk size: %.2f MB", float64(currentChunkSize)/1024/1024)
 
-		if err := d.appendToUploadSession(file, sessionID, offset, currentChunkSize); err != nil {
-			return fmt.Errorf("failed to append chunk: %v", err)
+		err := d.appendToUploadSession(file, sessionID, offset, currentChunkSize, false)
+		var incorrect *incorrectOffsetError
+		switch {
+		case errors.As(err, &incorrect) && incorrect.correct != offset && incorrect.correct <= fileSize:
+			// Continue from what the server has actually stored
+			logSubStep("↪️  Server has %.2f MB of the session, continuing from there", float64(incorrect.correct)/1024/1024)
+			offset = incorrect.correct
+		case errors.Is(err, errUploadSessionNotFound) && resumed:
+			logSubStep("⚠️  Upload session is gone, starting over")
+			d.forgetPendingUpload(targetPath)
+			return d.uploadLargeFile(file, targetPath, fileSize, chunkSize)
+		case err != nil:
+			return nil, fmt.Errorf("failed to append chunk: %v", err)
+		default:
+			logSubStep("✅ Chunk uploaded successfully")
+			offset += currentChunkSize
 		}
-		logSubStep("✅ Chunk uploaded successfully")
 
-		offset += currentChunkSize
-		currentChunk++
+		committed := offset
+		d.updatePendingUpload(targetPath, func(p *pendingUpload) { p.Offset = committed })
 	}
 
 	// Finish upload session
 	logStep("📤 Finalizing upload...")
-	if err := d.finishUploadSession(sessionID, targetPath, offset); err != nil {
-		return err
+	metadata, err := d.finishUploadSession(sessionID, targetPath, offset)
+	if err != nil {
+		return nil, err
 	}
+	d.forgetPendingUpload(targetPath)
 	logStep("✅ Upload completed successfully")
-	return nil
+	return metadata, nil
 }
 
-func (d *DropboxUploader) startUploadSession(file *os.File, chunkSize int64) (string, error) {
-	const uploadSessionStartURL = "https://content.dropboxapi.com/2/files/upload_session/start"
+// uploadLargeFileConcurrent uploads the chunks of a file through a
+// concurrent upload session, Parallelism chunks at a time. Every chunk is
+// appended at its own offset; the last one closes the session after the
+// others are stored.
+func (d *DropboxUploader) uploadLargeFileConcurrent(file *os.File, targetPath string, fileSize int64, chunkSize int64) (*DropboxEntry, error) {
+	logStep("📦 Starting parallel chunked upload process...")
+	logSubStep("Total file size: %.2f MB", float64(fileSize)/1024/1024)
+	logSubStep("Chunk size: %.2f MB", float64(chunkSize)/1024/1024)
+	totalChunks := (fileSize + chunkSize - 1) / chunkSize
+	logSubStep("Total chunks: %d", totalChunks)
+	logSubStep("Parallel uploads: %d", d.Parallelism)
+
+	var sessionID string
+	stored := make(map[int64]bool)
+	resumed := false
+	if pending := d.pendingUpload(file, targetPath, chunkSize, true); pending != nil {
+		sessionID, resumed = pending.SessionID, true
+		for _, chunk := range pending.Chunks {
+			stored[chunk] = true
+		}
+		logStep("🔁 Resuming upload session with %d/%d chunks stored", len(stored), totalChunks)
+		logSubStep("Session ID: %s", sessionID)
+	} else {
+		id, err := d.startConcurrentUploadSession()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start upload session: %v", err)
+		}
+		logSubStep("Session ID: %s", id)
+
+		sessionID = id
+		pending, err := newPendingUpload(file, sessionID, chunkSize, true)
+		if err != nil {
+			return nil, err
+		}
+		d.startPendingUpload(targetPath, pending)
+	}
+
+	appendChunk := func(chunk int64) error {
+		offset := chunk * chunkSize
+		size := chunkSize
+		if remaining := fileSize - offset; remaining < size {
+			size = remaining
+		}
+		last := chunk == totalChunks-1
+
+		logSubStep("📤 Uploading chunk %d/%d (offset %.2f MB)", chunk+1, totalChunks, float64(offset)/1024/1024)
+		if err := d.appendToUploadSession(file, sessionID, offset, size, last); err != nil {
+			return fmt.Errorf("failed to append chunk %d: %w", chunk+1, err)
+		}
+		logSubStep("✅ Chunk %d/%d uploaded", chunk+1, totalChunks)
+		d.updatePendingUpload(targetPath, func(p *pendingUpload) { p.Chunks = append(p.Chunks, chunk) })
+		return nil
+	}
 
+	// All chunks but the last are uploaded in parallel
+	chunks := make(chan int64)
+	errs := make(chan error, d.Parallelism)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < d.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				if err := appendChunk(chunk); err != nil {
+					errs <- err
+					stopOnce.Do(func() { close(stop) })
+					return
+				}
+			}
+		}()
+	}
+
+queue:
+	for chunk := int64(0); chunk < totalChunks-1; chunk++ {
+		if stored[chunk] {
+			continue
+		}
+		select {
+		case chunks <- chunk:
+		case <-stop:
+			break queue
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	close(errs)
+	err := <-errs
+	if errors.Is(err, errUploadSessionNotFound) && resumed {
+		logSubStep("⚠️  Upload session is gone, starting over")
+		d.forgetPendingUpload(targetPath)
+		return d.uploadLargeFileConcurrent(file, targetPath, fileSize, chunkSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The last chunk closes the session once everything else is stored
+	if !stored[totalChunks-1] {
+		if err := appendChunk(totalChunks - 1); err != nil {
+			return nil, err
+		}
+	}
+
+	logStep("📤 Finalizing upload...")
+	metadata, err := d.finishUploadSessionBatch(sessionID, targetPath, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	d.forgetPendingUpload(targetPath)
+	logStep("✅ Upload completed successfully")
+	return metadata, nil
+}
+
+// startConcurrentUploadSession starts an upload session that accepts
+// appends in any order. Data is only sent with append_v2.
+func (d *DropboxUploader) startConcurrentUploadSession() (string, error) {
+	argJSON, err := json.Marshal(map[string]interface{}{
+		"close":        false,
+		"session_type": "concurrent",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxSessionStartPath, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", string(argJSON))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("upload session start failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.SessionID, nil
+}
+
+// finishUploadSessionBatch commits a closed upload session with
+// finish_batch_v2, which concurrent sessions require
+func (d *DropboxUploader) finishUploadSessionBatch(sessionID string, targetPath string, offset int64) (*DropboxEntry, error) {
+	type cursor struct {
+		SessionID string `json:"session_id"`
+		Offset    int64  `json:"offset"`
+	}
+	type commit struct {
+		Path string `json:"path"`
+		Mode string `json:"mode"`
+	}
+	type entry struct {
+		Cursor cursor `json:"cursor"`
+		Commit commit `json:"commit"`
+	}
+	body, err := json.Marshal(struct {
+		Entries []entry `json:"entries"`
+	}{
+		Entries: []entry{{
+			Cursor: cursor{SessionID: sessionID, Offset: offset},
+			Commit: commit{Path: targetPath, Mode: "add"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.API+dropboxSessionFinishBatchPath, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("upload session finish failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// A successful entry carries the file metadata next to its tag
+	var result struct {
+		Entries []json.RawMessage `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("upload session finish returned %d entries", len(result.Entries))
+	}
+	var status struct {
+		Tag     string          `json:".tag"`
+		Failure json.RawMessage `json:"failure"`
+	}
+	if err := json.Unmarshal(result.Entries[0], &status); err != nil {
+		return nil, err
+	}
+	if status.Tag != "success" {
+		return nil, fmt.Errorf("upload session finish failed: %s", string(status.Failure))
+	}
+
+	var metadata DropboxEntry
+	if err := json.Unmarshal(result.Entries[0], &metadata); err != nil {
+		return nil, err
+	}
+	metadata.Tag = "file"
+	return &metadata, nil
+}
+
+func (d *DropboxUploader) startUploadSession(file *os.File, chunkSize int64) (string, error) {
 	logSubStep("Starting upload session...")
 	buffer := make([]byte, chunkSize)
-	n, err := file.Read(buffer)
+	n, err := file.ReadAt(buffer, 0)
 	if err != nil && err != io.EOF {
 		return "", err
 	}
 	logSubStep("Read %.2f MB from file", float64(n)/1024/1024)
 
-	req, err := http.NewRequest("POST", uploadSessionStartURL, bytes.NewReader(buffer[:n]))
-	if err != nil {
-		return "", err
-	}
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxSessionStartPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.setUploadBody(req, buffer[:n])
 
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Type", "application/octet-stream")
 
-	logSubStep("Sending request to Dropbox...")
-	resp, err := http.DefaultClient.Do(req)
+		logSubStep("Sending request to Dropbox...")
+		return req, nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -346,9 +830,7 @@ func (d *DropboxUploader) startUploadSession(file *os.File, chunkSize int64) (st
 	return result.SessionID, nil
 }
 
-func (d *DropboxUploader) appendToUploadSession(file *os.File, sessionID string, offset, chunkSize int64) error {
-	const uploadSessionAppendURL = "https://content.dropboxapi.com/2/files/upload_session/append_v2"
-
+func (d *DropboxUploader) appendToUploadSession(file *os.File, sessionID string, offset, chunkSize int64, close bool) error {
 	buffer := make([]byte, chunkSize)
 	n, err := file.ReadAt(buffer, offset)
 	if err != nil && err != io.EOF {
@@ -370,7 +852,7 @@ func (d *DropboxUploader) appendToUploadSession(file *os.File, sessionID string,
 			SessionID: sessionID,
 			Offset:    offset,
 		},
-		Close: false,
+		Close: close,
 	}
 
 	cursorJSON, err := json.Marshal(cursor)
@@ -378,16 +860,17 @@ func (d *DropboxUploader) appendToUploadSession(file *os.File, sessionID string,
 		return err
 	}
 
-	req, err := http.NewRequest("POST", uploadSessionAppendURL, bytes.NewReader(buffer[:n]))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Dropbox-API-Arg", string(cursorJSON))
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxSessionAppendPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.setUploadBody(req, buffer[:n])
 
-	resp, err := http.DefaultClient.Do(req)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", string(cursorJSON))
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -395,15 +878,27 @@ func (d *DropboxUploader) appendToUploadSession(file *os.File, sessionID string,
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		var appendErr struct {
+			Error struct {
+				Tag           string `json:".tag"`
+				CorrectOffset int64  `json:"correct_offset"`
+			} `json:"error"`
+		}
+		if resp.StatusCode == http.StatusConflict && json.Unmarshal(body, &appendErr) == nil {
+			switch appendErr.Error.Tag {
+			case "incorrect_offset":
+				return &incorrectOffsetError{correct: appendErr.Error.CorrectOffset}
+			case "not_found":
+				return errUploadSessionNotFound
+			}
+		}
 		return fmt.Errorf("upload session append failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
 	return nil
 }
 
-func (d *DropboxUploader) finishUploadSession(sessionID string, targetPath string, offset int64) error {
-	const uploadSessionFinishURL = "https://content.dropboxapi.com/2/files/upload_session/finish"
-
+func (d *DropboxUploader) finishUploadSession(sessionID string, targetPath string, offset int64) (*DropboxEntry, error) {
 	finishArg := struct {
 		Cursor struct {
 			SessionID string `json:"session_id"`
@@ -432,33 +927,48 @@ func (d *DropboxUploader) finishUploadSession(sessionID string, targetPath strin
 
 	finishArgJSON, err := json.Marshal(finishArg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", uploadSessionFinishURL, nil)
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Dropbox-API-Arg", string(finishArgJSON))
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxSessionFinishPath, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	resp, err := http.DefaultClient.Do(req)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", string(finishArgJSON))
+		return req, nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload session finish failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("upload session finish failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	var metadata DropboxEntry
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	metadata.Tag = "file"
+	return &metadata, nil
+}
+
+// setUploadBody makes data the throttled body of req. The body can be
+// replayed, e.g. after a token refresh.
+func (d *DropboxUploader) setUploadBody(req *http.Request, data []byte) {
+	req.Body = io.NopCloser(d.Limiter.reader(bytes.NewReader(data)))
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(d.Limiter.reader(bytes.NewReader(data))), nil
+	}
 }
 
-func (d *DropboxUploader) uploadSmallFile(file *os.File, targetPath string) error {
+func (d *DropboxUploader) uploadSmallFile(file *os.File, targetPath string) (*DropboxEntry, error) {
 	// Create API argument
 	apiArg := DropboxAPIArg{
 		Path:           targetPath,
@@ -470,32 +980,111 @@ func (d *DropboxUploader) uploadSmallFile(file *os.File, targetPath string) erro
 
 	apiArgJSON, err := json.Marshal(apiArg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal API argument: %v", err)
+		return nil, fmt.Errorf("failed to marshal API argument: %v", err)
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", dropboxUploadURL, file)
+	resp, err := d.doUpload(func() (*http.Request, error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// The transport closes request bodies, but retries reuse the file
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxUploadPath, io.NopCloser(d.Limiter.reader(file)))
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(d.Limiter.reader(file)), nil
+		}
+
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var metadata DropboxEntry
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	metadata.Tag = "file"
+	return &metadata, nil
+}
+
+// CreateFile uploads data to targetPath without renaming on conflict. It
+// returns errDropboxConflict if a file already exists at targetPath.
+func (d *DropboxUploader) CreateFile(targetPath string, data []byte) (*DropboxEntry, error) {
+	return d.writeFile(targetPath, "add", data)
+}
+
+// updateFile replaces revision rev of targetPath with data. It returns
+// errDropboxConflict if the file has changed or was deleted since.
+func (d *DropboxUploader) updateFile(targetPath, rev string, data []byte) (*DropboxEntry, error) {
+	return d.writeFile(targetPath, dropboxUpdateMode{Tag: "update", Update: rev}, data)
+}
+
+// writeFile uploads a small file with the given write mode, never renaming
+// on conflict
+func (d *DropboxUploader) writeFile(targetPath string, mode interface{}, data []byte) (*DropboxEntry, error) {
+	if err := d.ensureValidToken(); err != nil {
+		return nil, fmt.Errorf("failed to ensure valid token: %v", err)
+	}
+
+	// Create API argument
+	apiArg := DropboxAPIArg{
+		Path:           targetPath,
+		Mode:           mode,
+		AutoRename:     false,
+		Mute:           true,
+		StrictConflict: true,
+	}
+
+	apiArgJSON, err := json.Marshal(apiArg)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to marshal API argument: %v", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+d.accessToken)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+	// Create request
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.Content+dropboxUploadPath, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
 
-	// Make request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+		return req, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %v", err)
+		return nil, fmt.Errorf("failed to upload file: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusConflict && strings.Contains(string(body), "conflict") {
+			return nil, errDropboxConflict
+		}
+		return nil, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	var metadata DropboxEntry
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+	metadata.Tag = "file"
+	return &metadata, nil
 }
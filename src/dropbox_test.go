@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"volback/internal/fakedropbox"
+)
+
+// newTestUploader returns an uploader talking to a fake Dropbox server
+func newTestUploader(t *testing.T) (*DropboxUploader, *fakedropbox.Server) {
+	t.Helper()
+	server := fakedropbox.New()
+	t.Cleanup(server.Close)
+
+	uploader := NewDropboxUploader(server.RefreshToken, "client-id", "client-secret")
+	uploader.URLs = DropboxURLs{
+		API:     server.URL,
+		Content: server.URL,
+		Token:   server.URL + "/oauth2/token",
+	}
+	uploader.HTTPClient = server.Client()
+	return uploader, server
+}
+
+// writeTestFile writes size bytes of random data to a temporary file
+func writeTestFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	path := filepath.Join(t.TempDir(), "20250102.030405.7z")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func assertStored(t *testing.T, server *fakedropbox.Server, path string, want []byte) {
+	t.Helper()
+	file, ok := server.File(path)
+	if !ok {
+		t.Fatalf("%s was not stored, have %v", path, server.Paths())
+	}
+	if !bytes.Equal(file.Data, want) {
+		t.Fatalf("%s holds %d bytes that differ from the %d uploaded", path, len(file.Data), len(want))
+	}
+}
+
+func TestUploadSmallFile(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	source, data := writeTestFile(t, 1024)
+
+	metadata, err := uploader.Upload(source, "/backups/app/20250102.030405.7z")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	assertStored(t, server, "/backups/app/20250102.030405.7z", data)
+	if metadata.Size != int64(len(data)) || metadata.ContentHash != fakedropbox.ContentHash(data) {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if n := server.Calls("/2/files/upload_session/start"); n != 0 {
+		t.Errorf("small file started %d upload sessions", n)
+	}
+}
+
+func TestUploadChunked(t *testing.T) {
+	tests := []struct {
+		name        string
+		parallelism int
+		size        int
+		appends     int
+	}{
+		{"sequential", 1, 2*dropboxChunkUnit + 123, 2},
+		{"sequential exact chunks", 1, 3 * dropboxChunkUnit, 2},
+		{"concurrent", 3, 2*dropboxChunkUnit + 123, 3},
+		{"concurrent exact chunks", 2, 3 * dropboxChunkUnit, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader, server := newTestUploader(t)
+			uploader.ChunkSize = dropboxChunkUnit
+			uploader.Parallelism = tt.parallelism
+			source, data := writeTestFile(t, tt.size)
+
+			metadata, err := uploader.Upload(source, "/backups/app/archive.7z")
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			assertStored(t, server, "/backups/app/archive.7z", data)
+			if metadata.ContentHash != fakedropbox.ContentHash(data) {
+				t.Errorf("metadata carries content hash %s", metadata.ContentHash)
+			}
+			if n := server.Calls("/2/files/upload_session/append_v2"); n != tt.appends {
+				t.Errorf("made %d appends, want %d", n, tt.appends)
+			}
+		})
+	}
+}
+
+func TestUploadRejectsInvalidChunkSize(t *testing.T) {
+	uploader, _ := newTestUploader(t)
+	uploader.ChunkSize = dropboxChunkUnit + 1
+	source, _ := writeTestFile(t, 16)
+
+	if _, err := uploader.Upload(source, "/archive.7z"); err == nil {
+		t.Fatal("Upload accepted a chunk size that is not a multiple of 4 MB")
+	}
+}
+
+func TestDefaultChunkSizeIsValid(t *testing.T) {
+	f := registerDropboxFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	if err := validateChunkSize(int64(*f.chunkSize) * 1024 * 1024); err != nil {
+		t.Errorf("default flag value: %v", err)
+	}
+	if err := validateChunkSize(NewDropboxUploader("", "", "").ChunkSize); err != nil {
+		t.Errorf("uploader default: %v", err)
+	}
+}
+
+func TestUploadRetriesTransientErrors(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.FailNext("/2/files/upload", fakedropbox.Failure{
+		Status: 409,
+		Body:   `{"error_summary": "path/too_many_write_operations/..", "error": {".tag": "path"}}`,
+	})
+	source, data := writeTestFile(t, 1024)
+
+	if _, err := uploader.Upload(source, "/archive.7z"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	assertStored(t, server, "/archive.7z", data)
+	if n := server.Calls("/2/files/upload"); n != 2 {
+		t.Errorf("made %d upload requests, want 2", n)
+	}
+}
+
+func TestUploadDoesNotRetryPermanentErrors(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.FailNext("/2/files/upload", fakedropbox.Failure{
+		Status: 409,
+		Body:   `{"error_summary": "path/insufficient_space/..", "error": {".tag": "path"}}`,
+	})
+	source, _ := writeTestFile(t, 1024)
+
+	if _, err := uploader.Upload(source, "/archive.7z"); err == nil {
+		t.Fatal("Upload succeeded despite insufficient space")
+	}
+	if n := server.Calls("/2/files/upload"); n != 1 {
+		t.Errorf("made %d upload requests, want 1", n)
+	}
+}
+
+func TestCancelledUploadStopsRetrying(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.FailNext("/2/files/upload", fakedropbox.Failure{Status: 503, RetryAfter: "60"})
+	source, _ := writeTestFile(t, 1024)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	uploader.Context = ctx
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, err := uploader.Upload(source, "/archive.7z")
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("Upload returned %v, want a cancellation error", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Upload took %s after cancellation", elapsed)
+	}
+	if _, ok := server.File("/archive.7z"); ok {
+		t.Error("archive was stored after cancellation")
+	}
+}
+
+func TestExpiredTokenIsRefreshed(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.PutFile("/backups/app/a.7z", []byte("a"), time.Now())
+
+	if _, err := uploader.ListFolder("/backups/app", false); err != nil {
+		t.Fatalf("ListFolder: %v", err)
+	}
+	server.ExpireTokens()
+	entries, err := uploader.ListFolder("/backups/app", false)
+	if err != nil {
+		t.Fatalf("ListFolder after expiry: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("listed %d entries, want 1", len(entries))
+	}
+	if n := server.Calls("/oauth2/token"); n != 2 {
+		t.Errorf("requested %d tokens, want 2", n)
+	}
+}
+
+func TestRevokedRefreshTokenIsNotRetried(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	uploader.RefreshToken = "revoked-refresh-token"
+
+	started := time.Now()
+	_, err := uploader.ListFolder("/backups/app", false)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("ListFolder returned %v, want an invalid_grant error", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ListFolder took %s to fail", elapsed)
+	}
+	if n := server.Calls("/oauth2/token"); n != 1 {
+		t.Errorf("requested %d tokens, want 1", n)
+	}
+}
+
+func TestListFolderPages(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.PageSize = 10
+	for i := 0; i < 25; i++ {
+		server.PutFile(fmt.Sprintf("/backups/app/%02d.7z", i), []byte{byte(i)}, time.Now())
+	}
+	server.PutFile("/backups/app/nested/x.7z", []byte("x"), time.Now())
+
+	entries, err := uploader.ListFolder("/backups/app", false)
+	if err != nil {
+		t.Fatalf("ListFolder: %v", err)
+	}
+	files := 0
+	for _, entry := range entries {
+		if entry.IsFile() {
+			files++
+		}
+	}
+	if files != 25 || len(entries) != 26 {
+		t.Errorf("listed %d files in %d entries, want 25 in 26", files, len(entries))
+	}
+	if n := server.Calls("/2/files/list_folder/continue"); n != 2 {
+		t.Errorf("made %d continue requests, want 2", n)
+	}
+
+	if _, err := uploader.ListFolder("/missing", false); err != errDropboxNotFound {
+		t.Errorf("listing a missing folder returned %v", err)
+	}
+}
+
+func TestCreateFileConflict(t *testing.T) {
+	uploader, server := newTestUploader(t)
+
+	if _, err := uploader.CreateFile("/locks/app.lock", []byte("1")); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+	if _, err := uploader.CreateFile("/locks/app.lock", []byte("2")); err != errDropboxConflict {
+		t.Fatalf("second CreateFile returned %v, want a conflict", err)
+	}
+	assertStored(t, server, "/locks/app.lock", []byte("1"))
+}
+
+func TestConditionalDelete(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.PutFile("/locks/app.lock", []byte("old"), time.Now())
+	file, _ := server.File("/locks/app.lock")
+	server.PutFile("/locks/app.lock", []byte("new"), time.Now())
+
+	if err := uploader.deleteFile("/locks/app.lock", file.Rev); err != errDropboxConflict {
+		t.Fatalf("deleting an outdated revision returned %v, want a conflict", err)
+	}
+	assertStored(t, server, "/locks/app.lock", []byte("new"))
+
+	var buf bytes.Buffer
+	entry, err := uploader.download("/locks/app.lock", &buf)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if err := uploader.deleteFile("/locks/app.lock", entry.Rev); err != nil {
+		t.Fatalf("deleting the latest revision: %v", err)
+	}
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("server holds %v", paths)
+	}
+}
+
+func TestDeleteAndMoveBatch(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.AsyncBatches = true
+	server.PutFile("/a.7z", []byte("a"), time.Now())
+	server.PutFile("/b.7z", []byte("b"), time.Now())
+
+	if err := uploader.MoveBatch([]dropboxRelocation{{FromPath: "/a.7z", ToPath: "/old/a.7z"}}); err != nil {
+		t.Fatalf("MoveBatch: %v", err)
+	}
+	if err := uploader.DeleteBatch([]string{"/b.7z"}); err != nil {
+		t.Fatalf("DeleteBatch: %v", err)
+	}
+	if paths := server.Paths(); len(paths) != 1 || paths[0] != "/old/a.7z" {
+		t.Errorf("server holds %v, want [/old/a.7z]", paths)
+	}
+	if err := uploader.DeleteBatch([]string{"/b.7z"}); err == nil {
+		t.Error("deleting a missing file succeeded")
+	}
+}
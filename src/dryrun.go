@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// planRun shows what a backup run would do without side effects: which
+// containers would be stopped, which volumes archived, where the archives
+// would be uploaded and what retention would delete. Docker and Dropbox are
+// only read.
+func planRun(configs ContainerConfigs, uploader *DropboxUploader, opts RunOptions) error {
+	switch opts.StopMode {
+	case "", stopEntry, stopGroup:
+	default:
+		return fmt.Errorf("unknown stop mode: %s", opts.StopMode)
+	}
+
+	logHeader("🧪 Dry run: nothing is stopped, archived, uploaded or deleted")
+	ordered := topologicalOrder(configs)
+
+	if opts.StopMode == stopGroup {
+		var toStop []string
+		for i := len(ordered) - 1; i >= 0; i-- {
+			config := ordered[i]
+			if config.Project == "" && config.Volume == "" && shouldStop(config) {
+				toStop = append(toStop, configKey(config))
+			}
+		}
+		logStep("🛑 Would stop %d containers of the group, in this order:", len(toStop))
+		for _, key := range toStop {
+			logSubStep("%s", key)
+		}
+	}
+
+	var failed int
+	for _, config := range ordered {
+		if err := planEntry(config, uploader, opts); err != nil {
+			logStep("❌ %s: %v", configKey(config), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d entries could not be planned", failed)
+	}
+	return nil
+}
+
+// planEntry shows what backing up a single entry would do
+func planEntry(config ContainerConfig, uploader *DropboxUploader, opts RunOptions) error {
+	name := configName(config)
+	ep, err := opts.Endpoints.get(config.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	var volumes []Volume
+	switch {
+	case config.Project != "":
+		logHeader("📦 Would back up compose project: %s", name)
+		services, err := getProjectServices(ep, config.Project)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, s := range services {
+			if shouldStop(config) && s.Running {
+				logSubStep("🛑 Would stop %s (service: %s)", s.Container, s.Service)
+			}
+			projectVolumes, err := plannedVolumes(ep, s.Container)
+			if err != nil {
+				return err
+			}
+			for _, volume := range projectVolumes {
+				if !seen[volume.Source] {
+					seen[volume.Source] = true
+					volumes = append(volumes, volume)
+				}
+			}
+		}
+	case config.Volume != "":
+		logHeader("📦 Would back up volume: %s", name)
+		volumes = []Volume{{Name: config.Volume, Source: config.Volume, Type: "volume"}}
+	default:
+		logHeader("📦 Would back up container: %s", name)
+		// In group mode the stops are listed for the whole group
+		if shouldStop(config) && opts.StopMode != stopGroup {
+			logSubStep("🛑 Would stop %s during the backup", config.Container)
+		}
+		if volumes, err = plannedVolumes(ep, config.Container); err != nil {
+			return err
+		}
+	}
+	if config.Endpoint != "" {
+		logSubStep("Endpoint: %s", ep)
+	}
+
+	for _, volume := range volumes {
+		if reason := volumeSkipReason(volume); reason != "" {
+			logSubStep("⏭️  Would skip %s: %s", reason, volume.Destination)
+			continue
+		}
+		label := volume.Source
+		if volume.Name != "" {
+			label = volume.Name
+		}
+		if volume.Destination != "" {
+			label += " -> " + volume.Destination
+		}
+		logSubStep("💾 Would archive %s volume %s", volume.Type, label)
+	}
+
+	backupID := getBackupID(config)
+	now := time.Now()
+	target := path.Join("/", opts.DropboxPath, backupID, now.Format("20060102.150405")+".7z")
+	logSubStep("📤 Would upload to %s", target)
+	if config.Volume == "" {
+		logSubStep("📤 Would upload manifest to %s", strings.TrimSuffix(target, ".7z")+manifestSuffix)
+	}
+
+	policy := config.retentionPolicy(opts.Retention)
+	if uploader == nil || !policy.enabled() {
+		return nil
+	}
+	if err := planRetention(uploader, path.Join("/", opts.DropboxPath, backupID), target, policy, opts.Trash, now); err != nil {
+		return fmt.Errorf("retention dry run failed: %v", err)
+	}
+	return nil
+}
+
+// plannedVolumes returns the volumes of a container
+func plannedVolumes(ep *DockerEndpoint, container string) ([]Volume, error) {
+	volumeResult, err := getContainerVolumes(ep, container)
+	if err != nil {
+		return nil, err
+	}
+	if volumeResult.Status == "Failed" {
+		return nil, fmt.Errorf("failed to get volumes of %s: %s", container, volumeResult.Error)
+	}
+	return volumeResult.Volumes, nil
+}
+
+// planRetention shows what retention would keep and delete once the
+// archive at target is uploaded
+func planRetention(uploader *DropboxUploader, backupPath, target string, policy RetentionPolicy, trash TrashOptions, now time.Time) error {
+	logStep("🧹 Retention after the upload:")
+	existing, _, listErr := listBackups(uploader, backupPath)
+	if listErr != nil && !errors.Is(listErr, errDropboxNotFound) {
+		return listErr
+	}
+
+	backups, toKeep, err := retentionPlan(existing, target, policy, now)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		name := path.Base(backup.Path)
+		if backup.Path == target {
+			name += " (new)"
+		}
+		if reasons := toKeep[backup.Path]; len(reasons) > 0 {
+			logSubStep("📌 Would keep backup: %s (%s)", name, strings.Join(reasons, ", "))
+		} else {
+			logSubStep("🗑️  Would delete backup: %s (not selected by any rule)", name)
+		}
+	}
+	logSubStep("Would keep %d backups, would delete %d backups", len(toKeep), len(backups)-len(toKeep))
+
+	if trash.Enabled && listErr == nil {
+		if err := purgeTrash(uploader, backupPath, trash.Grace, true); err != nil {
+			logSubStep("⚠️  Failed to plan the trash purge: %v", err)
+		}
+	}
+	return nil
+}
+
+// retentionPlan selects the backups retention would keep after the archive
+// at target is uploaded. Retention runs after the upload, so the new
+// archive is selected together with the existing ones. The backups are
+// returned newest first.
+func retentionPlan(existing []Backup, target string, policy RetentionPolicy, now time.Time) ([]Backup, map[string][]string, error) {
+	created, err := parseBackupDateTime(path.Base(target))
+	if err != nil {
+		return nil, nil, err
+	}
+	backups := append([]Backup{{Path: target, DateTime: created}}, existing...)
+	sortBackups(backups)
+	return backups, selectRetained(backups, policy, now), nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// DockerEndpoint describes a Docker-compatible engine: the local Docker
+// socket, a Podman socket, or a remote host over TCP+TLS or ssh
+type DockerEndpoint struct {
+	Name      string `json:"name"`
+	Host      string `json:"host,omitempty"`
+	Context   string `json:"context,omitempty"`
+	TLSVerify bool   `json:"tls_verify,omitempty"`
+	CertPath  string `json:"cert_path,omitempty"`
+}
+
+// DockerEndpoints maps endpoint names to endpoints. The default endpoint
+// has an empty name.
+type DockerEndpoints map[string]*DockerEndpoint
+
+// resolveEndpoints builds the default endpoint from the environment and
+// adds the configured named endpoints
+func resolveEndpoints(defs []DockerEndpoint) (DockerEndpoints, error) {
+	def := &DockerEndpoint{
+		Host:      os.Getenv("DOCKER_HOST"),
+		Context:   os.Getenv("DOCKER_CONTEXT"),
+		TLSVerify: os.Getenv("DOCKER_TLS_VERIFY") != "",
+		CertPath:  os.Getenv("DOCKER_CERT_PATH"),
+	}
+	// DOCKER_HOST takes precedence over DOCKER_CONTEXT, as in the CLI
+	if def.Host != "" {
+		def.Context = ""
+	}
+	if err := def.resolve(); err != nil {
+		return nil, err
+	}
+
+	endpoints := DockerEndpoints{"": def}
+	for i := range defs {
+		ep := defs[i]
+		if ep.Name == "" {
+			return nil, fmt.Errorf("endpoint %d has no name", i+1)
+		}
+		if _, ok := endpoints[ep.Name]; ok {
+			return nil, fmt.Errorf("duplicate endpoint name: %s", ep.Name)
+		}
+		if err := ep.resolve(); err != nil {
+			return nil, fmt.Errorf("endpoint %s: %v", ep.Name, err)
+		}
+		endpoints[ep.Name] = &ep
+	}
+
+	return endpoints, nil
+}
+
+// get returns the endpoint with the given name
+func (e DockerEndpoints) get(name string) (*DockerEndpoint, error) {
+	ep, ok := e[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown endpoint: %s", name)
+	}
+	return ep, nil
+}
+
+// sorted returns the endpoints ordered by name, default first
+func (e DockerEndpoints) sorted() []*DockerEndpoint {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sorted := make([]*DockerEndpoint, 0, len(names))
+	for _, name := range names {
+		sorted = append(sorted, e[name])
+	}
+	return sorted
+}
+
+// resolve fills in Host and TLS settings from a docker context, and falls
+// back to the default socket, so that SDK calls and CLI invocations always
+// address the same engine
+func (e *DockerEndpoint) resolve() error {
+	if e.Context != "" && e.Context != "default" {
+		if err := e.loadContext(); err != nil {
+			return err
+		}
+	}
+	if e.Host == "" {
+		e.Host = client.DefaultDockerHost
+	}
+	if _, err := url.Parse(e.Host); err != nil {
+		return fmt.Errorf("invalid host %q: %v", e.Host, err)
+	}
+	return nil
+}
+
+// loadContext reads the endpoint of a docker context from the CLI
+// configuration directory
+func (e *DockerEndpoint) loadContext() error {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to locate docker config directory: %v", err)
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+
+	// Context directories are named after the SHA-256 of the context name
+	sum := sha256.Sum256([]byte(e.Context))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return fmt.Errorf("failed to read docker context %s: %v", e.Context, err)
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string `json:"Host"`
+			SkipTLSVerify bool   `json:"SkipTLSVerify"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("failed to parse docker context %s: %v", e.Context, err)
+	}
+
+	docker, ok := meta.Endpoints["docker"]
+	if !ok || docker.Host == "" {
+		return fmt.Errorf("docker context %s has no docker endpoint", e.Context)
+	}
+	e.Host = docker.Host
+
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(filepath.Join(tlsDir, "ca.pem")); err == nil {
+		e.CertPath = tlsDir
+		e.TLSVerify = !docker.SkipTLSVerify
+	}
+
+	return nil
+}
+
+// scheme returns the URL scheme of the endpoint host
+func (e *DockerEndpoint) scheme() string {
+	scheme, _, _ := strings.Cut(e.Host, "://")
+	return scheme
+}
+
+// isLocal reports whether the engine shares this machine's filesystem, so
+// that bind mounts of local paths reach volback's working directory
+func (e *DockerEndpoint) isLocal() bool {
+	switch e.scheme() {
+	case "unix", "npipe":
+		return true
+	}
+	return false
+}
+
+// String returns a description of the endpoint for logs
+func (e *DockerEndpoint) String() string {
+	if e.Name == "" {
+		return e.Host
+	}
+	return e.Name + " (" + e.Host + ")"
+}
+
+// newClient creates an SDK client for the endpoint. The API version is
+// negotiated because Podman and older engines only support older versions.
+func (e *DockerEndpoint) newClient() (*client.Client, error) {
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+
+	switch e.scheme() {
+	case "ssh":
+		dialer, err := sshDialer(e.Host)
+		if err != nil {
+			return nil, err
+		}
+		// The host is only used to build request URLs; connections go
+		// through the dialer, which must be set after the host
+		opts = append(opts,
+			client.WithHost("http://docker.example.com"),
+			client.WithDialContext(dialer),
+		)
+	case "tcp":
+		if e.CertPath != "" {
+			tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
+				CAFile:             filepath.Join(e.CertPath, "ca.pem"),
+				CertFile:           filepath.Join(e.CertPath, "cert.pem"),
+				KeyFile:            filepath.Join(e.CertPath, "key.pem"),
+				InsecureSkipVerify: !e.TLSVerify,
+				ExclusiveRootPools: true,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to load TLS configuration: %v", err)
+			}
+			opts = append(opts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+		}
+		opts = append(opts, client.WithHost(e.Host))
+	default:
+		opts = append(opts, client.WithHost(e.Host))
+	}
+
+	return client.NewClientWithOpts(opts...)
+}
+
+// cliArgs prefixes docker CLI arguments with the global options that point
+// the CLI at this endpoint
+func (e *DockerEndpoint) cliArgs(args ...string) []string {
+	global := []string{"--host", e.Host}
+	if e.scheme() == "tcp" && e.CertPath != "" {
+		if e.TLSVerify {
+			global = append(global, "--tlsverify")
+		} else {
+			global = append(global, "--tls")
+		}
+		global = append(global,
+			"--tlscacert", filepath.Join(e.CertPath, "ca.pem"),
+			"--tlscert", filepath.Join(e.CertPath, "cert.pem"),
+			"--tlskey", filepath.Join(e.CertPath, "key.pem"),
+		)
+	}
+	return append(global, args...)
+}
+
+// sshDialer connects to a remote engine the way the docker CLI does: by
+// running "docker system dial-stdio" on the remote host over ssh
+func sshDialer(host string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh host %q: %v", host, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in %q", host)
+	}
+
+	args := []string{"-o", "ConnectTimeout=30"}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return newCommandConn("ssh", args...)
+	}, nil
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func newCommandConn(name string, args ...string) (net.Conn, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %v", name, err)
+	}
+
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+func (c *commandConn) CloseWrite() error           { return c.stdin.Close() }
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return dummyAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return dummyAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string { return "dummy" }
+func (dummyAddr) String() string  { return "dummy" }
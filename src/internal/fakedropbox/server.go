@@ -0,0 +1,904 @@
+// Package fakedropbox is an in-memory stand-in for the parts of the Dropbox
+// API volback uses: the token endpoint, simple and session uploads,
+// downloads, folder listings, deletes and batch moves. It lets the uploader
+// be tested without network access.
+package fakedropbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkUnit is the size concurrent session appends must be a multiple of
+const chunkUnit = 4 * 1024 * 1024
+
+// File is a file stored by the server
+type File struct {
+	Path     string // display path
+	ID       string
+	Rev      string // changes with every write
+	Data     []byte
+	Modified time.Time
+}
+
+// Failure is a response returned instead of handling a request
+type Failure struct {
+	Status     int
+	Body       string
+	RetryAfter string // value of the Retry-After header, if any
+}
+
+type session struct {
+	concurrent bool
+	closed     bool
+	data       []byte           // sequential sessions
+	chunks     map[int64][]byte // concurrent sessions, by offset
+}
+
+// Server is a fake Dropbox API served over HTTP
+type Server struct {
+	*httptest.Server
+
+	RefreshToken  string // refresh token accepted by the token endpoint
+	PageSize      int    // entries per list_folder page, all if 0
+	AsyncBatches  bool   // batch jobs must be polled through their check endpoint
+	HomeNamespace string
+	RootNamespace string
+
+	mu       sync.Mutex
+	files    map[string]*File // by lower case path
+	sessions map[string]*session
+	cursors  map[string][]json.RawMessage
+	jobs     map[string][]json.RawMessage
+	tokens   map[string]bool
+	failures map[string][]Failure
+	calls    map[string]int
+	headers  map[string]http.Header // of the last request, by endpoint
+	nextID   int
+}
+
+// New starts a fake Dropbox server. It must be closed after use.
+func New() *Server {
+	s := &Server{
+		RefreshToken:  "fake-refresh-token",
+		HomeNamespace: "1",
+		RootNamespace: "1",
+		files:         make(map[string]*File),
+		sessions:      make(map[string]*session),
+		cursors:       make(map[string][]json.RawMessage),
+		jobs:          make(map[string][]json.RawMessage),
+		tokens:        make(map[string]bool),
+		failures:      make(map[string][]Failure),
+		calls:         make(map[string]int),
+		headers:       make(map[string]http.Header),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", s.handleToken)
+	routes := map[string]func(w http.ResponseWriter, r *http.Request){
+		"/2/files/upload":                         s.handleUpload,
+		"/2/files/download":                       s.handleDownload,
+		"/2/files/upload_session/start":           s.handleSessionStart,
+		"/2/files/upload_session/append_v2":       s.handleSessionAppend,
+		"/2/files/upload_session/finish":          s.handleSessionFinish,
+		"/2/files/upload_session/finish_batch_v2": s.handleSessionFinishBatch,
+		"/2/files/list_folder":                    s.handleListFolder,
+		"/2/files/list_folder/continue":           s.handleListFolderContinue,
+		"/2/files/delete_v2":                      s.handleDelete,
+		"/2/files/delete_batch":                   s.handleDeleteBatch,
+		"/2/files/delete_batch/check":             s.handleBatchCheck,
+		"/2/files/move_batch_v2":                  s.handleMoveBatch,
+		"/2/files/move_batch/check_v2":            s.handleBatchCheck,
+		"/2/users/get_current_account":            s.handleCurrentAccount,
+	}
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, s.authenticated(handler))
+	}
+	s.Server = httptest.NewServer(s.intercept(mux))
+	return s
+}
+
+// PutFile stores data at p, replacing any file there
+func (s *Server) PutFile(p string, data []byte, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(p, data, modified)
+}
+
+// File returns the file stored at p
+func (s *Server) File(p string) (File, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[strings.ToLower(p)]
+	if !ok {
+		return File{}, false
+	}
+	return *f, true
+}
+
+// Paths returns the paths of all stored files, sorted
+func (s *Server) Paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, f := range s.files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// FailNext makes the next request to endpoint fail with f. Failures of an
+// endpoint are returned in the order they were added.
+func (s *Server) FailNext(endpoint string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = append(s.failures[endpoint], f)
+}
+
+// ExpireTokens makes every issued access token expired
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// Calls returns how many requests were made to endpoint
+func (s *Server) Calls(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// Headers returns the headers of the last request made to endpoint
+func (s *Server) Headers(endpoint string) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headers[endpoint].Clone()
+}
+
+// ContentHash computes the Dropbox content hash of data: the SHA-256 of
+// the concatenated SHA-256 digests of its 4 MB blocks
+func ContentHash(data []byte) string {
+	overall := sha256.New()
+	for start := 0; start < len(data); start += chunkUnit {
+		sum := sha256.Sum256(data[start:min(start+chunkUnit, len(data))])
+		overall.Write(sum[:])
+	}
+	return hex.EncodeToString(overall.Sum(nil))
+}
+
+// intercept counts and records requests and returns injected failures
+func (s *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[r.URL.Path]++
+		s.headers[r.URL.Path] = r.Header.Clone()
+		var failure *Failure
+		if queue := s.failures[r.URL.Path]; len(queue) > 0 {
+			failure = &queue[0]
+			s.failures[r.URL.Path] = queue[1:]
+		}
+		s.mu.Unlock()
+
+		if failure != nil {
+			io.Copy(io.Discard, r.Body)
+			if failure.RetryAfter != "" {
+				w.Header().Set("Retry-After", failure.RetryAfter)
+			}
+			w.WriteHeader(failure.Status)
+			io.WriteString(w, failure.Body)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticated rejects requests without a valid access token
+func (s *Server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := s.tokens[token]
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "expired_access_token/", map[string]string{".tag": "expired_access_token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	switch {
+	case r.PostForm.Get("grant_type") == "refresh_token" && r.PostForm.Get("refresh_token") == s.RefreshToken:
+	case r.PostForm.Get("grant_type") == "authorization_code" && r.PostForm.Get("code") != "":
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	token := fmt.Sprintf("fake-access-token-%d", s.nextID)
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  token,
+		"expires_in":    14400,
+		"token_type":    "bearer",
+		"refresh_token": s.RefreshToken,
+	})
+}
+
+type commitInfo struct {
+	Path           string    `json:"path"`
+	Mode           writeMode `json:"mode"`
+	AutoRename     bool      `json:"autorename"`
+	StrictConflict bool      `json:"strict_conflict"`
+}
+
+// writeMode is "add", "overwrite" or {".tag": "update", "update": rev},
+// which replaces the file only while it is still at revision rev
+type writeMode struct {
+	Tag    string
+	Update string
+}
+
+func (m *writeMode) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Tag); err == nil {
+		return nil
+	}
+	var v struct {
+		Tag    string `json:".tag"`
+		Update string `json:"update"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	m.Tag, m.Update = v.Tag, v.Update
+	return nil
+}
+
+type uploadCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	var arg commitInfo
+	if !readArg(w, r, &arg) {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.commit(arg, data)
+	if !ok {
+		writeConflict(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata(f, false))
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Path string `json:"path"`
+	}
+	if !readArg(w, r, &arg) {
+		return
+	}
+
+	s.mu.Lock()
+	f, ok := s.files[strings.ToLower(arg.Path)]
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	result, _ := json.Marshal(metadata(f, false))
+	w.Header().Set("Dropbox-API-Result", string(result))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(f.Data)
+}
+
+func (s *Server) handleSessionStart(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Close       bool   `json:"close"`
+		SessionType string `json:"session_type"`
+	}
+	if !readArg(w, r, &arg) {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	sess := &session{concurrent: arg.SessionType == "concurrent", closed: arg.Close}
+	if sess.concurrent {
+		if len(data) > 0 {
+			writeError(w, http.StatusBadRequest, "concurrent sessions cannot start with data", nil)
+			return
+		}
+		sess.chunks = make(map[int64][]byte)
+	} else {
+		sess.data = data
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("session-%d", s.nextID)
+	s.sessions[id] = sess
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"session_id": id})
+}
+
+func (s *Server) handleSessionAppend(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Cursor uploadCursor `json:"cursor"`
+		Close  bool         `json:"close"`
+	}
+	if !readArg(w, r, &arg) {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[arg.Cursor.SessionID]
+	switch {
+	case !ok:
+		writeError(w, http.StatusConflict, "not_found/", map[string]string{".tag": "not_found"})
+		return
+	case sess.closed:
+		writeError(w, http.StatusConflict, "closed/", map[string]string{".tag": "closed"})
+		return
+	}
+
+	if sess.concurrent {
+		if !arg.Close && len(data)%chunkUnit != 0 {
+			writeError(w, http.StatusBadRequest, "concurrent appends must be a multiple of 4 MB", nil)
+			return
+		}
+		sess.chunks[arg.Cursor.Offset] = data
+	} else {
+		if arg.Cursor.Offset != int64(len(sess.data)) {
+			writeError(w, http.StatusConflict, "incorrect_offset/", map[string]interface{}{
+				".tag":           "incorrect_offset",
+				"correct_offset": len(sess.data),
+			})
+			return
+		}
+		sess.data = append(sess.data, data...)
+	}
+	sess.closed = arg.Close
+	writeJSON(w, http.StatusOK, nil)
+}
+
+func (s *Server) handleSessionFinish(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Cursor uploadCursor `json:"cursor"`
+		Commit commitInfo   `json:"commit"`
+	}
+	if !readArg(w, r, &arg) {
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[arg.Cursor.SessionID]
+	if !ok || sess.concurrent {
+		writeError(w, http.StatusConflict, "lookup_failed/not_found/", map[string]string{".tag": "lookup_failed"})
+		return
+	}
+	if arg.Cursor.Offset != int64(len(sess.data)) {
+		writeError(w, http.StatusConflict, "lookup_failed/incorrect_offset/", map[string]interface{}{
+			".tag":           "incorrect_offset",
+			"correct_offset": len(sess.data),
+		})
+		return
+	}
+	f, ok := s.commit(arg.Commit, append(sess.data, data...))
+	if !ok {
+		writeConflict(w)
+		return
+	}
+	delete(s.sessions, arg.Cursor.SessionID)
+	writeJSON(w, http.StatusOK, metadata(f, false))
+}
+
+func (s *Server) handleSessionFinishBatch(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Entries []struct {
+			Cursor uploadCursor `json:"cursor"`
+			Commit commitInfo   `json:"commit"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []interface{}
+	for _, entry := range arg.Entries {
+		data, reason := s.assemble(entry.Cursor)
+		if reason != "" {
+			results = append(results, map[string]interface{}{
+				".tag":    "failure",
+				"failure": map[string]string{".tag": "lookup_failed", "reason": reason},
+			})
+			continue
+		}
+		f, ok := s.commit(entry.Commit, data)
+		if !ok {
+			results = append(results, map[string]interface{}{
+				".tag":    "failure",
+				"failure": map[string]string{".tag": "path", "reason": "conflict"},
+			})
+			continue
+		}
+		delete(s.sessions, entry.Cursor.SessionID)
+		result := metadata(f, false)
+		result[".tag"] = "success"
+		results = append(results, result)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": results})
+}
+
+// assemble joins the chunks of a closed concurrent session, returning a
+// failure reason if they do not cover exactly cursor.Offset bytes
+func (s *Server) assemble(cursor uploadCursor) ([]byte, string) {
+	sess, ok := s.sessions[cursor.SessionID]
+	switch {
+	case !ok:
+		return nil, "not_found"
+	case !sess.concurrent:
+		return sess.data, ""
+	case !sess.closed:
+		return nil, "not_closed"
+	}
+
+	var data []byte
+	used := 0
+	for int64(len(data)) < cursor.Offset {
+		chunk, ok := sess.chunks[int64(len(data))]
+		if !ok || len(chunk) == 0 {
+			return nil, "incorrect_offset"
+		}
+		data = append(data, chunk...)
+		used++
+	}
+	if int64(len(data)) != cursor.Offset || used != len(sess.chunks) {
+		return nil, "incorrect_offset"
+	}
+	return data, ""
+}
+
+func (s *Server) handleListFolder(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Path      string `json:"path"`
+		Recursive bool   `json:"recursive"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, ok := s.list(arg.Path, arg.Recursive)
+	if !ok {
+		writeError(w, http.StatusConflict, "path/not_found/", map[string]interface{}{
+			".tag": "path",
+			"path": map[string]string{".tag": "not_found"},
+		})
+		return
+	}
+	s.writePage(w, entries)
+}
+
+func (s *Server) handleListFolderContinue(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Cursor string `json:"cursor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, ok := s.cursors[arg.Cursor]
+	if !ok {
+		writeError(w, http.StatusConflict, "reset/", map[string]string{".tag": "reset"})
+		return
+	}
+	delete(s.cursors, arg.Cursor)
+	s.writePage(w, entries)
+}
+
+// writePage writes the first page of entries and keeps the rest behind a
+// cursor
+func (s *Server) writePage(w http.ResponseWriter, entries []json.RawMessage) {
+	page := entries
+	if s.PageSize > 0 && len(page) > s.PageSize {
+		page = entries[:s.PageSize]
+	}
+	s.nextID++
+	cursor := fmt.Sprintf("cursor-%d", s.nextID)
+	hasMore := len(page) < len(entries)
+	if hasMore {
+		s.cursors[cursor] = entries[len(page):]
+	}
+	if page == nil {
+		page = []json.RawMessage{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries":  page,
+		"cursor":   cursor,
+		"has_more": hasMore,
+	})
+}
+
+// list returns the files and folders in dir, or below it if recursive.
+// Folders exist implicitly as long as they contain a file.
+func (s *Server) list(dir string, recursive bool) ([]json.RawMessage, bool) {
+	dir = strings.TrimSuffix(strings.ToLower(dir), "/")
+	if _, isFile := s.files[dir]; isFile {
+		return nil, false
+	}
+
+	found := dir == ""
+	folders := make(map[string]string)
+	var entries []json.RawMessage
+	for _, lower := range s.sortedKeys() {
+		if !strings.HasPrefix(lower, dir+"/") {
+			continue
+		}
+		found = true
+		f := s.files[lower]
+		prefix := f.Path[:len(dir)+1]
+		parts := strings.Split(f.Path[len(prefix):], "/")
+		for i := 1; i < len(parts) && (recursive || i == 1); i++ {
+			folder := prefix + strings.Join(parts[:i], "/")
+			folders[strings.ToLower(folder)] = folder
+		}
+		if len(parts) == 1 || recursive {
+			data, _ := json.Marshal(metadata(f, true))
+			entries = append(entries, data)
+		}
+	}
+
+	var names []string
+	for lower := range folders {
+		names = append(names, lower)
+	}
+	sort.Strings(names)
+	var folderEntries []json.RawMessage
+	for _, lower := range names {
+		data, _ := json.Marshal(map[string]string{
+			".tag":         "folder",
+			"name":         path.Base(folders[lower]),
+			"path_display": folders[lower],
+			"path_lower":   lower,
+			"id":           "id:folder" + lower,
+		})
+		folderEntries = append(folderEntries, data)
+	}
+	return append(folderEntries, entries...), found
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Path      string `json:"path"`
+		ParentRev string `json:"parent_rev"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[strings.ToLower(arg.Path)]; ok && arg.ParentRev != "" && f.Rev != arg.ParentRev {
+		writeError(w, http.StatusConflict, "path_write/conflict/file/", map[string]interface{}{
+			".tag":       "path_write",
+			"path_write": map[string]interface{}{".tag": "conflict", "conflict": map[string]string{".tag": "file"}},
+		})
+		return
+	}
+	deleted, ok := s.remove(arg.Path)
+	if !ok {
+		writeError(w, http.StatusConflict, "path_lookup/not_found/", map[string]interface{}{
+			".tag":        "path_lookup",
+			"path_lookup": map[string]string{".tag": "not_found"},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"metadata": deleted})
+}
+
+func (s *Server) handleDeleteBatch(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Entries []struct {
+			Path string `json:"path"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []json.RawMessage
+	for _, entry := range arg.Entries {
+		var result interface{}
+		if deleted, ok := s.remove(entry.Path); ok {
+			result = map[string]interface{}{".tag": "success", "metadata": deleted}
+		} else {
+			result = map[string]interface{}{
+				".tag":    "failure",
+				"failure": map[string]interface{}{".tag": "path_lookup", "path_lookup": map[string]string{".tag": "not_found"}},
+			}
+		}
+		data, _ := json.Marshal(result)
+		results = append(results, data)
+	}
+	s.writeJob(w, results)
+}
+
+func (s *Server) handleMoveBatch(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Entries []struct {
+			FromPath string `json:"from_path"`
+			ToPath   string `json:"to_path"`
+		} `json:"entries"`
+		AutoRename bool `json:"autorename"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []json.RawMessage
+	for _, entry := range arg.Entries {
+		var result interface{}
+		f, ok := s.files[strings.ToLower(entry.FromPath)]
+		if ok {
+			var moved *File
+			if moved, ok = s.commit(commitInfo{Path: entry.ToPath, AutoRename: arg.AutoRename}, f.Data); ok {
+				delete(s.files, strings.ToLower(entry.FromPath))
+				result = map[string]interface{}{".tag": "success", "success": metadata(moved, true)}
+			} else {
+				result = map[string]interface{}{".tag": "failure", "failure": map[string]string{".tag": "to", "to": "conflict"}}
+			}
+		} else {
+			result = map[string]interface{}{".tag": "failure", "failure": map[string]string{".tag": "from_lookup", "from_lookup": "not_found"}}
+		}
+		data, _ := json.Marshal(result)
+		results = append(results, data)
+	}
+	s.writeJob(w, results)
+}
+
+// writeJob answers a batch request, either with its results or with the
+// ID of a job to poll when AsyncBatches is set
+func (s *Server) writeJob(w http.ResponseWriter, results []json.RawMessage) {
+	if results == nil {
+		results = []json.RawMessage{}
+	}
+	if !s.AsyncBatches {
+		writeJSON(w, http.StatusOK, map[string]interface{}{".tag": "complete", "entries": results})
+		return
+	}
+	s.nextID++
+	id := fmt.Sprintf("job-%d", s.nextID)
+	s.jobs[id] = results
+	writeJSON(w, http.StatusOK, map[string]string{".tag": "async_job_id", "async_job_id": id})
+}
+
+func (s *Server) handleBatchCheck(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		AsyncJobID string `json:"async_job_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&arg); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results, ok := s.jobs[arg.AsyncJobID]
+	if !ok {
+		writeError(w, http.StatusConflict, "invalid_async_job_id/", map[string]string{".tag": "invalid_async_job_id"})
+		return
+	}
+	delete(s.jobs, arg.AsyncJobID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{".tag": "complete", "entries": results})
+}
+
+func (s *Server) handleCurrentAccount(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"account_id": "dbid:fake",
+		"root_info": map[string]string{
+			".tag":              "user",
+			"root_namespace_id": s.RootNamespace,
+			"home_namespace_id": s.HomeNamespace,
+		},
+	})
+}
+
+// commit stores data according to a commit, renaming it if the path is
+// taken and renaming is allowed. It reports false on a conflict, which
+// includes an update of a revision that is no longer current.
+func (s *Server) commit(info commitInfo, data []byte) (*File, bool) {
+	p := info.Path
+	existing, exists := s.files[strings.ToLower(p)]
+	if info.Mode.Tag == "update" {
+		if !exists || existing.Rev != info.Mode.Update {
+			return nil, false
+		}
+		return s.store(p, data, time.Now().UTC()), true
+	}
+	if exists && info.Mode.Tag != "overwrite" {
+		if !info.AutoRename {
+			return nil, false
+		}
+		ext := path.Ext(p)
+		base := strings.TrimSuffix(p, ext)
+		for i := 1; ; i++ {
+			p = fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if _, exists := s.files[strings.ToLower(p)]; !exists {
+				break
+			}
+		}
+	}
+	return s.store(p, data, time.Now().UTC()), true
+}
+
+func (s *Server) store(p string, data []byte, modified time.Time) *File {
+	s.nextID++
+	f := &File{
+		Path:     p,
+		ID:       fmt.Sprintf("id:%d", s.nextID),
+		Rev:      fmt.Sprintf("%09x", s.nextID),
+		Data:     append([]byte(nil), data...),
+		Modified: modified.UTC().Truncate(time.Second),
+	}
+	s.files[strings.ToLower(p)] = f
+	return f
+}
+
+// remove deletes the file at p, or every file below p if it is a folder,
+// and returns the metadata of what was deleted
+func (s *Server) remove(p string) (map[string]interface{}, bool) {
+	lower := strings.ToLower(p)
+	if f, ok := s.files[lower]; ok {
+		delete(s.files, lower)
+		return metadata(f, true), true
+	}
+
+	var display string
+	for key, f := range s.files {
+		if strings.HasPrefix(key, lower+"/") {
+			display = f.Path[:len(p)]
+			delete(s.files, key)
+		}
+	}
+	if display == "" {
+		return nil, false
+	}
+	return map[string]interface{}{
+		".tag":         "folder",
+		"name":         path.Base(display),
+		"path_display": display,
+		"path_lower":   lower,
+	}, true
+}
+
+func (s *Server) sortedKeys() []string {
+	keys := make([]string, 0, len(s.files))
+	for key := range s.files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metadata describes a file the way Dropbox does. Listings and batch
+// results tag their entries.
+func metadata(f *File, tagged bool) map[string]interface{} {
+	m := map[string]interface{}{
+		"name":            path.Base(f.Path),
+		"path_display":    f.Path,
+		"path_lower":      strings.ToLower(f.Path),
+		"id":              f.ID,
+		"rev":             f.Rev,
+		"size":            len(f.Data),
+		"server_modified": f.Modified.Format(time.RFC3339),
+		"client_modified": f.Modified.Format(time.RFC3339),
+		"content_hash":    ContentHash(f.Data),
+	}
+	if tagged {
+		m[".tag"] = "file"
+	}
+	return m
+}
+
+// readArg decodes the Dropbox-API-Arg header of a content request. A
+// missing header leaves every argument at its default.
+func readArg(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Header.Get("Dropbox-API-Arg") == "" {
+		return true
+	}
+	if err := json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid Dropbox-API-Arg: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+func writeConflict(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, "path/conflict/file/", map[string]interface{}{
+		".tag":   "path",
+		"reason": map[string]string{".tag": "conflict"},
+	})
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, "path/not_found/", map[string]interface{}{
+		".tag": "path",
+		"path": map[string]string{".tag": "not_found"},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, summary string, detail interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"error_summary": summary,
+		"error":         detail,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
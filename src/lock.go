@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lock modes
+const (
+	lockNone    = "none"
+	lockFile    = "file"
+	lockDropbox = "dropbox"
+)
+
+// dropboxLockName is the lock object stored in an entry's backup folder
+const dropboxLockName = ".volback.lock"
+
+// errLocked reports that an entry is being backed up by another run
+var errLocked = errors.New("locked by another run")
+
+// LockOptions controls how overlapping runs are detected
+type LockOptions struct {
+	Mode string        // none, file or dropbox
+	Dir  string        // directory holding file locks
+	Wait bool          // wait for the lock instead of skipping the run
+	TTL  time.Duration // age after which a Dropbox lock is considered stale
+}
+
+// dropboxLockRefreshes is how often a held Dropbox lock is refreshed per
+// TTL, so that a slow refresh does not let it turn stale
+const dropboxLockRefreshes = 3
+
+// entryLock is a held lock on a single entry
+type entryLock interface {
+	release()
+}
+
+// acquireRunLocks locks every entry of a run so that a second run, in
+// this or another process, cannot stop and start the same containers
+// concurrently. Entries are locked in sorted order so that waiting runs
+// cannot deadlock.
+func acquireRunLocks(configs ContainerConfigs, uploader *DropboxUploader, opts RunOptions) (func(), error) {
+	if opts.Lock.Mode == "" || opts.Lock.Mode == lockNone {
+		return func() {}, nil
+	}
+
+	sorted := append(ContainerConfigs{}, configs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return configKey(sorted[i]) < configKey(sorted[j])
+	})
+
+	var held []entryLock
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].release()
+		}
+	}
+
+	for _, config := range sorted {
+		var lock entryLock
+		var err error
+		switch opts.Lock.Mode {
+		case lockFile:
+			lock, err = acquireFileLock(opts.Lock, configKey(config))
+		case lockDropbox:
+			lockPath := path.Join("/", opts.DropboxPath, getBackupID(config), dropboxLockName)
+			lock, err = acquireDropboxLock(uploader, opts.Lock, lockPath)
+		default:
+			err = fmt.Errorf("unknown lock mode: %s", opts.Lock.Mode)
+		}
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%s: %w", configKey(config), err)
+		}
+		held = append(held, lock)
+	}
+
+	return release, nil
+}
+
+// fileLock is an flock on a file in the lock directory. Mount the
+// directory from the host to exclude runs in other containers.
+type fileLock struct {
+	file *os.File
+}
+
+func acquireFileLock(opts LockOptions, key string) (*fileLock, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+
+	name := strings.ReplaceAll(key, "/", "_") + ".lock"
+	file, err := os.OpenFile(filepath.Join(opts.Dir, name), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK && opts.Wait {
+		logSubStep("⏳ Waiting for lock on %s...", key)
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", file.Name(), err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) release() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
+
+// dropboxLock is a lock object on Dropbox, shared by volback instances on
+// different hosts that write to the same backup folder. While it is held
+// its creation time is refreshed, so uploads that take longer than the TTL
+// keep the lock.
+type dropboxLock struct {
+	uploader *DropboxUploader
+	path     string
+	rev      string // revision written last; owned by refresh until done
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// dropboxLockInfo is the content of a Dropbox lock object
+type dropboxLockInfo struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Created time.Time `json:"created"`
+}
+
+func acquireDropboxLock(uploader *DropboxUploader, opts LockOptions, lockPath string) (*dropboxLock, error) {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(dropboxLockInfo{Host: host, PID: os.Getpid(), Created: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		entry, err := uploader.CreateFile(lockPath, data)
+		if err == nil {
+			lock := &dropboxLock{
+				uploader: uploader,
+				path:     lockPath,
+				rev:      entry.Rev,
+				stop:     make(chan struct{}),
+				done:     make(chan struct{}),
+			}
+			go lock.refresh(host, opts.TTL/dropboxLockRefreshes)
+			return lock, nil
+		}
+		if err != errDropboxConflict {
+			return nil, fmt.Errorf("failed to create lock %s: %v", lockPath, err)
+		}
+
+		// Remove locks left behind by runs that died. Only the revision
+		// that was read is deleted, so a lock another run took over in
+		// the meantime is kept.
+		var buf bytes.Buffer
+		var info dropboxLockInfo
+		entry, err = uploader.download(lockPath, &buf)
+		if err == nil && json.Unmarshal(buf.Bytes(), &info) == nil && time.Since(info.Created) > opts.TTL {
+			logSubStep("🧹 Removing stale lock %s from %s (created %s)", lockPath, info.Host, info.Created.Format(time.RFC3339))
+			if err := uploader.deleteFile(lockPath, entry.Rev); err != nil && err != errDropboxConflict {
+				return nil, fmt.Errorf("failed to remove stale lock %s: %v", lockPath, err)
+			}
+			continue
+		}
+
+		if !opts.Wait {
+			return nil, errLocked
+		}
+		logSubStep("⏳ Waiting for lock %s held by %s...", lockPath, info.Host)
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// refresh rewrites the lock with a new creation time every interval until
+// the lock is released. Only the revision written last is replaced, so a
+// lock another run took over is left alone.
+func (l *dropboxLock) refresh(host string, interval time.Duration) {
+	defer close(l.done)
+	if interval <= 0 {
+		<-l.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := json.Marshal(dropboxLockInfo{Host: host, PID: os.Getpid(), Created: time.Now().UTC()})
+		if err != nil {
+			continue
+		}
+		entry, err := l.uploader.updateFile(l.path, l.rev, data)
+		if err == errDropboxConflict {
+			logSubStep("⚠️  Lock %s was taken over by another run", l.path)
+			<-l.stop
+			return
+		}
+		if err != nil {
+			logSubStep("⚠️  Failed to refresh lock %s: %v", l.path, err)
+			continue
+		}
+		l.rev = entry.Rev
+	}
+}
+
+func (l *dropboxLock) release() {
+	close(l.stop)
+	<-l.done
+
+	if err := l.uploader.deleteFile(l.path, l.rev); err != nil {
+		if err == errDropboxConflict {
+			err = fmt.Errorf("it was taken over by another run")
+		}
+		logSubStep("⚠️  Failed to release lock %s: %v", l.path, err)
+	}
+}
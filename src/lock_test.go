@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStaleDropboxLockIsReplaced(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	stale, _ := json.Marshal(dropboxLockInfo{Host: "gone", PID: 1, Created: time.Now().Add(-2 * time.Hour)})
+	server.PutFile("/backups/app/"+dropboxLockName, stale, time.Now())
+
+	opts := LockOptions{Mode: lockDropbox, TTL: time.Hour}
+	lock, err := acquireDropboxLock(uploader, opts, "/backups/app/"+dropboxLockName)
+	if err != nil {
+		t.Fatalf("acquireDropboxLock: %v", err)
+	}
+	file, ok := server.File("/backups/app/" + dropboxLockName)
+	if !ok || string(file.Data) == string(stale) {
+		t.Fatal("the stale lock was not replaced")
+	}
+
+	// A live lock is not taken over
+	if _, err := acquireDropboxLock(uploader, opts, "/backups/app/"+dropboxLockName); err != errLocked {
+		t.Fatalf("second acquireDropboxLock returned %v, want errLocked", err)
+	}
+	lock.release()
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("server holds %v after release", paths)
+	}
+}
+
+func TestDropboxLockIsRefreshedWhileHeld(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	lockPath := "/backups/app/" + dropboxLockName
+	opts := LockOptions{Mode: lockDropbox, TTL: 300 * time.Millisecond}
+
+	lock, err := acquireDropboxLock(uploader, opts, lockPath)
+	if err != nil {
+		t.Fatalf("acquireDropboxLock: %v", err)
+	}
+
+	// Outlive the TTL; the refreshed lock must not be taken for stale
+	time.Sleep(2 * opts.TTL)
+	if _, err := acquireDropboxLock(uploader, opts, lockPath); err != errLocked {
+		t.Fatalf("second acquireDropboxLock returned %v, want errLocked", err)
+	}
+
+	lock.release()
+	if paths := server.Paths(); len(paths) != 0 {
+		t.Errorf("server holds %v after release", paths)
+	}
+}
+
+func TestTakenOverDropboxLockIsKept(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	lockPath := "/backups/app/" + dropboxLockName
+	opts := LockOptions{Mode: lockDropbox, TTL: 300 * time.Millisecond}
+
+	lock, err := acquireDropboxLock(uploader, opts, lockPath)
+	if err != nil {
+		t.Fatalf("acquireDropboxLock: %v", err)
+	}
+
+	// Another run replaces the lock, e.g. after a refresh failed for long
+	other, _ := json.Marshal(dropboxLockInfo{Host: "other", PID: 2, Created: time.Now()})
+	server.PutFile(lockPath, other, time.Now())
+	time.Sleep(opts.TTL)
+
+	lock.release()
+	file, ok := server.File(lockPath)
+	if !ok || string(file.Data) != string(other) {
+		t.Error("the lock of the other run was not kept")
+	}
+}
@@ -1,76 +1,384 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 func main() {
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		case "restore-container":
+			runRestoreContainer(os.Args[2:])
+			return
+		case "auth":
+			runAuth(os.Args[2:])
+			return
+		}
+	}
+
 	logHeader("=== Docker Volume Backup Utility ===")
 
-	// Define flags
-	containersJSON := flag.String("containers", os.Getenv("CONTAINERS"), "JSON array of container configurations")
-	dropboxRefreshToken := flag.String("dropbox-refresh-token", os.Getenv("DROPBOX_REFRESH_TOKEN"), "Dropbox refresh token")
-	dropboxClientID := flag.String("dropbox-client-id", os.Getenv("DROPBOX_CLIENT_ID"), "Dropbox client ID")
-	dropboxClientSecret := flag.String("dropbox-client-secret", os.Getenv("DROPBOX_CLIENT_SECRET"), "Dropbox client secret")
-	dropboxPath := flag.String("dropbox-path", os.Getenv("DROPBOX_PATH"), "Dropbox destination path (e.g., /backups)")
+	backup := registerBackupFlags(flag.CommandLine)
+	flag.Parse()
+
+	if !backup.dropbox.valid() {
+		logStep("❌ Dropbox configuration is required")
+		os.Exit(1)
+	}
+
+	// Initialize Dropbox uploader
+	uploader, err := backup.newUploader()
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+
+	if err := backup.run(uploader); err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+
+	logHeader("✨ Backup process completed successfully!")
+}
+
+// dropboxFlags holds the Dropbox settings shared by all commands
+type dropboxFlags struct {
+	refreshToken   *string
+	clientID       *string
+	clientSecret   *string
+	path           *string
+	chunkSize      *int
+	parallelism    *int
+	uploadLimit    *string
+	uploadSchedule *string
+	selectUser     *string
+	pathRoot       *string
+}
+
+func registerDropboxFlags(fs *flag.FlagSet) *dropboxFlags {
+	return &dropboxFlags{
+		refreshToken:   fs.String("dropbox-refresh-token", os.Getenv("DROPBOX_REFRESH_TOKEN"), "Dropbox refresh token"),
+		clientID:       fs.String("dropbox-client-id", os.Getenv("DROPBOX_CLIENT_ID"), "Dropbox client ID"),
+		clientSecret:   fs.String("dropbox-client-secret", os.Getenv("DROPBOX_CLIENT_SECRET"), "Dropbox client secret"),
+		path:           fs.String("dropbox-path", os.Getenv("DROPBOX_PATH"), "Dropbox destination path (e.g., /backups)"),
+		chunkSize:      fs.Int("upload-chunk-size", getEnvInt("UPLOAD_CHUNK_SIZE", 148), "Upload chunk size in MB (a multiple of 4, at most 148)"),
+		parallelism:    fs.Int("upload-parallelism", getEnvInt("UPLOAD_PARALLELISM", 1), "Number of chunks of a large file uploaded in parallel"),
+		uploadLimit:    fs.String("upload-limit", os.Getenv("UPLOAD_LIMIT"), "Upload bandwidth cap (e.g., 10MB or 5Mbit per second; 0 for unlimited)"),
+		selectUser:     fs.String("dropbox-select-user", os.Getenv("DROPBOX_SELECT_USER"), "Team member ID whose space is used, for team-scoped apps"),
+		pathRoot:       fs.String("dropbox-path-root", os.Getenv("DROPBOX_PATH_ROOT"), "Namespace ID paths are relative to, or \"team\" for the team space"),
+		uploadSchedule: fs.String("upload-limit-schedule", os.Getenv("UPLOAD_LIMIT_SCHEDULE"), "Time-of-day upload caps overriding -upload-limit (e.g., 08:00-18:00=5Mbit,18:00-22:00=20Mbit)"),
+	}
+}
+
+func (f *dropboxFlags) valid() bool {
+	return *f.refreshToken != "" && *f.clientID != "" && *f.clientSecret != ""
+}
+
+func (f *dropboxFlags) newUploader() (*DropboxUploader, error) {
+	limiter, err := newBandwidthLimiter(*f.uploadLimit, *f.uploadSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload limit: %v", err)
+	}
+
+	uploader := NewDropboxUploader(*f.refreshToken, *f.clientID, *f.clientSecret)
+	uploader.ChunkSize = int64(*f.chunkSize) * 1024 * 1024
+	uploader.Parallelism = *f.parallelism
+	uploader.Limiter = limiter
+	uploader.SelectUser = *f.selectUser
+	if err := uploader.configurePathRoot(*f.pathRoot); err != nil {
+		return nil, err
+	}
+	return uploader, nil
+}
+
+// backupFlags holds the settings of a backup run, shared by the one-shot
+// command and the daemon
+type backupFlags struct {
+	dropbox        *dropboxFlags
+	containersJSON *string
+	endpointsJSON  *string
+	volumesJSON    *string
+	allVolumes     *bool
+	volumeLabels   *string
+	redactEnv      *bool
+	discover       *bool
+	stateDir       *string
+	lockMode       *string
+	lockDir        *string
+	lockWait       *bool
+	lockTTL        *time.Duration
+	concurrency    *int
+	stopMode       *string
+	dryRun         *bool
 
 	// Retention flags
-	keepDaily := flag.Int("keep-daily", getEnvInt("KEEP_DAILY", 0), "Number of daily backups to keep")
-	keepWeekly := flag.Int("keep-weekly", getEnvInt("KEEP_WEEKLY", 0), "Number of weekly backups to keep")
-	keepMonthly := flag.Int("keep-monthly", getEnvInt("KEEP_MONTHLY", 0), "Number of monthly backups to keep")
-	keepYearly := flag.Int("keep-yearly", getEnvInt("KEEP_YEARLY", 0), "Number of yearly backups to keep")
+	keepLast    *int
+	keepHourly  *int
+	keepDaily   *int
+	keepWeekly  *int
+	keepMonthly *int
+	keepYearly  *int
+	keepWithin  *time.Duration
+	minAge      *time.Duration
+	trash       *bool
+	trashGrace  *time.Duration
+
+	// One store is shared by every run and the uploader, so that its lock
+	// serialises all state updates
+	stateOnce sync.Once
+	state     *stateStore
+}
 
-	flag.Parse()
+func registerBackupFlags(fs *flag.FlagSet) *backupFlags {
+	return &backupFlags{
+		dropbox:        registerDropboxFlags(fs),
+		containersJSON: fs.String("containers", os.Getenv("CONTAINERS"), "JSON array of container configurations"),
+		endpointsJSON:  fs.String("endpoints", os.Getenv("ENDPOINTS"), "JSON array of named Docker endpoints (e.g., remote hosts or Podman sockets)"),
+		volumesJSON:    fs.String("volumes", os.Getenv("VOLUMES"), "JSON array of named volume configurations"),
+		allVolumes:     fs.Bool("all-volumes", getEnvBool("ALL_VOLUMES", false), "Back up every named volume matching -volume-labels"),
+		volumeLabels:   fs.String("volume-labels", os.Getenv("VOLUME_LABELS"), "Comma-separated label filters for -all-volumes (e.g., backup=true)"),
+		redactEnv:      fs.Bool("redact-env", getEnvBool("REDACT_ENV", false), "Redact secret-looking environment variables in captured container configuration"),
+		discover:       fs.Bool("discover", getEnvBool("DISCOVER", false), "Discover containers labelled volback.enable=true"),
+		stateDir:       fs.String("state-dir", getEnvString("STATE_DIR", "/var/lib/volback"), "Directory for persistent state such as last successful runs"),
+		lockMode:       fs.String("lock", getEnvString("LOCK", lockFile), "How overlapping runs are detected: none, file or dropbox"),
+		lockDir:        fs.String("lock-dir", getEnvString("LOCK_DIR", "/tmp/volback-locks"), "Directory for file locks; mount it from the host to cover all containers"),
+		lockWait:       fs.Bool("lock-wait", getEnvBool("LOCK_WAIT", false), "Wait for overlapping runs to finish instead of skipping"),
+		concurrency:    fs.Int("concurrency", getEnvInt("CONCURRENCY", 1), "Number of independent entries to back up in parallel"),
+		stopMode:       fs.String("stop-mode", getEnvString("STOP_MODE", stopEntry), "How containers are stopped: entry (one at a time) or group (dependents stopped before and started after their dependencies)"),
+		dryRun:         fs.Bool("dry-run", getEnvBool("DRY_RUN", false), "Show which containers would be stopped, which volumes archived, where archives would be uploaded and what retention would delete, without doing it"),
+		lockTTL:        longDurationFlag(fs, "lock-ttl", getEnvDuration("LOCK_TTL", 24*time.Hour), "Age after which a Dropbox lock is considered stale; held locks are refreshed well within it"),
+
+		keepLast:    fs.Int("keep-last", getEnvInt("KEEP_LAST", 0), "Number of most recent backups to keep"),
+		keepHourly:  fs.Int("keep-hourly", getEnvInt("KEEP_HOURLY", 0), "Number of hourly backups to keep"),
+		keepDaily:   fs.Int("keep-daily", getEnvInt("KEEP_DAILY", 0), "Number of daily backups to keep"),
+		keepWeekly:  fs.Int("keep-weekly", getEnvInt("KEEP_WEEKLY", 0), "Number of weekly backups to keep"),
+		keepMonthly: fs.Int("keep-monthly", getEnvInt("KEEP_MONTHLY", 0), "Number of monthly backups to keep"),
+		keepYearly:  fs.Int("keep-yearly", getEnvInt("KEEP_YEARLY", 0), "Number of yearly backups to keep"),
+		keepWithin:  longDurationFlag(fs, "keep-within", getEnvDuration("KEEP_WITHIN", 0), "Keep all backups this close to the latest one (e.g., 30d)"),
+		minAge:      longDurationFlag(fs, "min-age", getEnvDuration("MIN_AGE", 0), "Never delete backups younger than this (e.g., 36h)"),
+		trash:       fs.Bool("trash", getEnvBool("TRASH", false), "Move expired backups to a .trash/<date> folder instead of deleting them"),
+		trashGrace:  longDurationFlag(fs, "trash-grace", getEnvDuration("TRASH_GRACE", 30*24*time.Hour), "How long trashed backups are kept before they are purged"),
+	}
+}
+
+// store returns the state store of the state directory
+func (f *backupFlags) store() *stateStore {
+	f.stateOnce.Do(func() {
+		f.state = newStateStore(*f.stateDir)
+	})
+	return f.state
+}
+
+// newUploader creates the uploader of backup runs, which keeps upload
+// sessions in the state directory so they can be resumed
+func (f *backupFlags) newUploader() (*DropboxUploader, error) {
+	uploader, err := f.dropbox.newUploader()
+	if err != nil {
+		return nil, err
+	}
+	uploader.State = f.store()
+	return uploader, nil
+}
+
+// resumeUploads finishes uploads interrupted by a restart. The backups
+// they belong to are then completed as if the upload had never been
+// interrupted: the manifest is stored, retention applied and the outcome
+// reported.
+func (f *backupFlags) resumeUploads(uploader *DropboxUploader) {
+	resumed := uploader.ResumePendingUploads()
+	if len(resumed) == 0 {
+		return
+	}
+
+	opts := f.runOptions(nil)
+	opts.Report = newRunReport()
+	defer opts.Report.save(opts.State)
+
+	for _, r := range resumed {
+		if r.Upload.Entry == nil {
+			if err := os.Remove(r.Upload.Source); err != nil {
+				logSubStep("⚠️  Failed to remove %s: %v", r.Upload.Source, err)
+			}
+			if dir := filepath.Dir(r.Upload.Source); isArchiveTempDir(dir) {
+				(&entryArchive{tempDir: dir}).cleanup()
+			}
+			continue
+		}
+
+		config := *r.Upload.Entry
+		archive := &entryArchive{config: config, tempDir: filepath.Dir(r.Upload.Source), snapshots: r.Upload.Snapshots}
+		if err := archive.finish(uploader, opts, r.Target, r.Metadata); err != nil {
+			logStep("❌ %s: %v", configKey(config), err)
+			opts.Report.failed(config, err)
+		} else {
+			opts.State.recordSuccess(configKey(config), time.Now())
+		}
+		archive.cleanup()
+	}
+}
+
+// run builds the configuration of a backup run and processes it.
+// Configuration is rebuilt on every run so that discovery sees the
+// current containers and volumes.
+func (f *backupFlags) run(uploader *DropboxUploader) error {
+	// Finish uploads interrupted by a restart first
+	if !*f.dryRun {
+		f.resumeUploads(uploader)
+	}
+
+	endpoints, configs, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	logStep("📋 Found %d entries to process", len(configs))
+
+	// Process all containers
+	if err := processContainers(context.Background(), configs, uploader, f.runOptions(endpoints)); err != nil {
+		return fmt.Errorf("failed to process containers: %v", err)
+	}
+	return nil
+}
+
+// load resolves the endpoints and builds the entries of a run
+func (f *backupFlags) load() (DockerEndpoints, ContainerConfigs, error) {
+	endpoints, err := loadEndpoints(*f.endpointsJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load Docker endpoints: %v", err)
+	}
+
+	configs, err := f.loadConfigs(endpoints)
+	if err != nil {
+		return nil, nil, err
+	}
+	return endpoints, configs, nil
+}
 
+// loadConfigs merges the explicit, discovered and listed entries
+func (f *backupFlags) loadConfigs(endpoints DockerEndpoints) (ContainerConfigs, error) {
 	// Parse container configurations
 	var configs ContainerConfigs
-	if err := json.Unmarshal([]byte(*containersJSON), &configs); err != nil {
-		logStep("❌ Failed to parse container configurations: %v", err)
-		os.Exit(1)
+	if *f.containersJSON != "" {
+		if err := json.Unmarshal([]byte(*f.containersJSON), &configs); err != nil {
+			return nil, fmt.Errorf("failed to parse container configurations: %v", err)
+		}
+	}
+
+	// Merge in containers discovered through labels
+	if *f.discover {
+		for _, ep := range endpoints.sorted() {
+			logStep("🔍 Discovering containers by label on %s...", ep)
+			discovered, err := discoverContainerConfigs(ep)
+			if err != nil {
+				return nil, fmt.Errorf("failed to discover containers: %v", err)
+			}
+			logSubStep("Discovered %d labelled containers", len(discovered))
+			configs = mergeContainerConfigs(configs, discovered)
+		}
+	}
+
+	// Parse volume configurations
+	var volumes []VolumeConfig
+	if *f.volumesJSON != "" {
+		if err := json.Unmarshal([]byte(*f.volumesJSON), &volumes); err != nil {
+			return nil, fmt.Errorf("failed to parse volume configurations: %v", err)
+		}
+	}
+	configs = volumeConfigs(configs, volumes)
+
+	// Merge in all volumes matching the label filters
+	if *f.allVolumes {
+		for _, ep := range endpoints.sorted() {
+			logStep("🔍 Listing named volumes on %s...", ep)
+			names, err := listNamedVolumes(ep, splitList(*f.volumeLabels))
+			if err != nil {
+				return nil, fmt.Errorf("failed to list volumes: %v", err)
+			}
+			logSubStep("Found %d named volumes", len(names))
+			var listed []VolumeConfig
+			for _, name := range names {
+				listed = append(listed, VolumeConfig{Volume: name, Endpoint: ep.Name})
+			}
+			configs = volumeConfigs(configs, listed)
+		}
 	}
 
 	// Validate inputs
 	if len(configs) == 0 {
-		logStep("❌ No container configurations provided")
-		os.Exit(1)
+		return nil, fmt.Errorf("no container or volume configurations provided")
 	}
 
-	if *dropboxRefreshToken == "" || *dropboxClientID == "" || *dropboxClientSecret == "" {
-		logStep("❌ Dropbox configuration is required")
-		os.Exit(1)
+	for _, config := range configs {
+		if _, err := endpoints.get(config.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid configuration for %s: %v", configName(config), err)
+		}
 	}
 
-	// Initialize Dropbox uploader
-	uploader := NewDropboxUploader(
-		*dropboxRefreshToken,
-		*dropboxClientID,
-		*dropboxClientSecret,
-	)
-
-	// Create retention policy
-	retentionPolicy := RetentionPolicy{
-		KeepDaily:   *keepDaily,
-		KeepWeekly:  *keepWeekly,
-		KeepMonthly: *keepMonthly,
-		KeepYearly:  *keepYearly,
+	if err := validateDependencies(configs); err != nil {
+		return nil, fmt.Errorf("invalid depends_on: %v", err)
 	}
 
-	logStep("📋 Found %d containers to process", len(configs))
+	return configs, nil
+}
 
-	// Process all containers
-	if err := processContainers(configs, uploader, *dropboxPath, retentionPolicy); err != nil {
-		logStep("❌ Failed to process containers: %v", err)
-		os.Exit(1)
+func (f *backupFlags) runOptions(endpoints DockerEndpoints) RunOptions {
+	return RunOptions{
+		DropboxPath: *f.dropbox.path,
+		Retention: RetentionPolicy{
+			KeepLast:    *f.keepLast,
+			KeepHourly:  *f.keepHourly,
+			KeepDaily:   *f.keepDaily,
+			KeepWeekly:  *f.keepWeekly,
+			KeepMonthly: *f.keepMonthly,
+			KeepYearly:  *f.keepYearly,
+			KeepWithin:  *f.keepWithin,
+			MinAge:      *f.minAge,
+		},
+		Trash: TrashOptions{
+			Enabled: *f.trash,
+			Grace:   *f.trashGrace,
+		},
+		RedactEnv:   *f.redactEnv,
+		Endpoints:   endpoints,
+		State:       f.store(),
+		Concurrency: *f.concurrency,
+		StopMode:    *f.stopMode,
+		DryRun:      *f.dryRun,
+		Lock: LockOptions{
+			Mode: *f.lockMode,
+			Dir:  *f.lockDir,
+			Wait: *f.lockWait,
+			TTL:  *f.lockTTL,
+		},
 	}
+}
 
-	logHeader("✨ Backup process completed successfully!")
+// loadEndpoints parses the -endpoints JSON and resolves all endpoints
+func loadEndpoints(endpointsJSON string) (DockerEndpoints, error) {
+	var defs []DockerEndpoint
+	if endpointsJSON != "" {
+		if err := json.Unmarshal([]byte(endpointsJSON), &defs); err != nil {
+			return nil, fmt.Errorf("failed to parse endpoints: %v", err)
+		}
+	}
+	return resolveEndpoints(defs)
 }
 
 func getEnvInt(key string, defaultVal int) int {
@@ -82,131 +390,391 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
-func processContainers(configs ContainerConfigs, uploader *DropboxUploader, dropboxPath string, retentionPolicy RetentionPolicy) error {
-	// Create dependency graph
-	dependencies := make(map[string][]string)
-	for _, config := range configs {
-		if len(config.DependsOn) > 0 {
-			dependencies[config.Container] = config.DependsOn
-		}
-	}
-
-	// Process containers in correct order
-	processed := make(map[string]bool)
-	var processContainer func(config ContainerConfig) error
-	processContainer = func(config ContainerConfig) error {
-		if processed[config.Container] {
-			return nil
-		}
-
-		// Process dependencies
-		if deps, ok := dependencies[config.Container]; ok {
-			for _, dep := range deps {
-				for _, depConfig := range configs {
-					if depConfig.Container == dep {
-						if err := processContainer(depConfig); err != nil {
-							return err
-						}
-						break
-					}
-				}
-			}
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
+	}
+	return items
+}
 
-		logHeader("📦 Processing container: %s", config.Container)
+func getEnvString(key string, defaultVal string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultVal
+}
 
-		// Stop container if required
-		if shouldStop(config) {
-			if err := stopDockerContainer(config.Container); err != nil {
-				return err
-			}
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := parseLongDuration(value); err == nil {
+			return d
 		}
+	}
+	return defaultVal
+}
 
-		// Create temporary working directory
-		tempDir := filepath.Join("/tmp", "volback-"+config.Container+"-"+time.Now().Format("20060102150405"))
-		if err := os.MkdirAll(tempDir, 0755); err != nil {
-			return fmt.Errorf("failed to create temporary directory: %v", err)
+// longDurationUnits matches the day and week units parseLongDuration adds,
+// including fractions such as 1.5d
+var longDurationUnits = regexp.MustCompile(`([0-9.]+)([dw])`)
+
+// parseLongDuration parses a duration that may also use days and weeks,
+// e.g. 30d, 1.5w or 1d12h
+func parseLongDuration(value string) (time.Duration, error) {
+	invalid := fmt.Errorf("invalid duration %q", value)
+	valid := true
+	converted := longDurationUnits.ReplaceAllStringFunc(value, func(part string) string {
+		n, err := strconv.ParseFloat(part[:len(part)-1], 64)
+		unit := 24 * time.Hour
+		if part[len(part)-1] == 'w' {
+			unit *= 7
 		}
+		if err != nil || n*float64(unit) >= math.MaxInt64 {
+			valid = false
+			return part
+		}
+		// Nanoseconds keep fractions of days exact
+		return strconv.FormatInt(int64(math.Round(n*float64(unit))), 10) + "ns"
+	})
+	if !valid {
+		return 0, invalid
+	}
+	d, err := time.ParseDuration(converted)
+	if err != nil {
+		return 0, invalid
+	}
+	return d, nil
+}
 
-		// Move cleanup to after all operations are complete
-		defer func() {
-			logStep("🧹 Cleaning up temporary directory: %s", tempDir)
-			if err := os.RemoveAll(tempDir); err != nil {
-				logSubStep("⚠️  Failed to remove temporary directory %s: %v", tempDir, err)
-			}
-		}()
+// longDuration is a flag value parsed with parseLongDuration
+type longDuration time.Duration
 
-		// Get and process volumes
-		volumeResult, err := getContainerVolumes(config.Container)
-		if err != nil {
-			return err
-		}
-		if volumeResult.Status == "Failed" {
-			return fmt.Errorf("failed to get container volumes: %s", volumeResult.Error)
+func (d *longDuration) String() string { return time.Duration(*d).String() }
+
+func (d *longDuration) Set(value string) error {
+	parsed, err := parseLongDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = longDuration(parsed)
+	return nil
+}
+
+// longDurationFlag defines a duration flag that accepts days and weeks
+func longDurationFlag(fs *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	fs.Var((*longDuration)(p), name, usage)
+	return p
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
 		}
+	}
+	return defaultVal
+}
+
+// processContainers backs up the entries of a run. Once ctx is done no
+// further entries are started.
+func processContainers(ctx context.Context, configs ContainerConfigs, uploader *DropboxUploader, opts RunOptions) error {
+	if opts.DryRun {
+		return planRun(configs, uploader, opts)
+	}
+
+	// Make sure no other run is backing up the same entries
+	release, err := acquireRunLocks(configs, uploader, opts)
+	if errors.Is(err, errLocked) {
+		logStep("⏭️  Skipping run: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Record the outcome of every entry in a run report
+	opts.Report = newRunReport()
+	defer opts.Report.save(opts.State)
+
+	switch opts.StopMode {
+	case "", stopEntry:
+	case stopGroup:
+		return processStopGroup(ctx, configs, uploader, opts)
+	default:
+		return fmt.Errorf("unknown stop mode: %s", opts.StopMode)
+	}
 
-		if err := processVolumes(config.Container, volumeResult.Volumes, tempDir); err != nil {
+	// Independent entries run in parallel; depends_on is respected
+	if opts.Concurrency > 1 {
+		logStep("⚡ Processing up to %d entries in parallel", opts.Concurrency)
+	}
+	return runDAG(configs, opts.Concurrency, func(config ContainerConfig) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-
-		// Start container if it was stopped
-		if shouldStop(config) {
-			if err := startDockerContainer(config.Container); err != nil {
-				return err
-			}
+		if err := backupEntry(config, uploader, opts); err != nil {
+			opts.Report.failed(config, err)
+			return err
 		}
+		if opts.State != nil {
+			opts.State.recordSuccess(configKey(config), time.Now())
+		}
+		return nil
+	})
+}
+
+// backupEntry archives a single configuration entry, uploads the archive
+// and applies the retention policy
+func backupEntry(config ContainerConfig, uploader *DropboxUploader, opts RunOptions) error {
+	archive, err := archiveEntry(config, opts, shouldStop(config))
+	if archive != nil {
+		defer archive.cleanup()
+	}
+	if err != nil {
+		return err
+	}
+	return archive.upload(uploader, opts)
+}
 
-		// Upload to Dropbox
-		if uploader != nil {
-			logHeader("📤 Uploading backup to Dropbox...")
-			timestamp := time.Now().Format("20060102.150405")
-			backupFileName := timestamp + ".7z"
-			localBackupPath := filepath.Join(tempDir, config.Container+".7z")
+// Entry archives are created in a directory named archiveTempPrefix plus
+// the entry key below archiveTempRoot
+const (
+	archiveTempRoot   = "/tmp"
+	archiveTempPrefix = "volback-"
+)
 
-			// Use the helper function to get the backup ID
-			backupID := getBackupID(config)
-			dropboxTargetPath := filepath.Join(dropboxPath, backupID, backupFileName)
+// entryArchive is the local archive of an entry waiting to be uploaded
+type entryArchive struct {
+	config    ContainerConfig
+	tempDir   string
+	snapshots []ContainerSnapshot
+	resumable bool // an interrupted upload session still needs the archive
+}
 
-			if !strings.HasPrefix(dropboxTargetPath, "/") {
-				dropboxTargetPath = "/" + dropboxTargetPath
-			}
+// archiveEntry creates the archive of an entry in a new temporary
+// directory. stop controls whether a container entry is stopped for the
+// duration of the backup. The returned archive must be cleaned up even
+// when an error is returned.
+func archiveEntry(config ContainerConfig, opts RunOptions, stop bool) (*entryArchive, error) {
+	name := configName(config)
+	switch {
+	case config.Project != "":
+		logHeader("📦 Processing compose project: %s", name)
+	case config.Volume != "":
+		logHeader("📦 Processing volume: %s", name)
+	default:
+		logHeader("📦 Processing container: %s", name)
+	}
 
-			logStep("📁 Uploading to Dropbox: %s", dropboxTargetPath)
-			if err := uploader.Upload(localBackupPath, dropboxTargetPath); err != nil {
-				return fmt.Errorf("dropbox upload failed: %v", err)
-			}
-			logStep("✅ Backup successfully uploaded to Dropbox")
-
-			// Apply retention policy
-			if retentionPolicy.KeepDaily > 0 || retentionPolicy.KeepWeekly > 0 ||
-				retentionPolicy.KeepMonthly > 0 || retentionPolicy.KeepYearly > 0 {
-				// Use the helper function here as well
-				retentionPath := filepath.Join(dropboxPath, backupID)
-				if err := manageRetention(uploader, retentionPath, retentionPolicy); err != nil {
-					return fmt.Errorf("retention management failed: %v", err)
-				}
-			}
-		}
+	ep, err := opts.Endpoints.get(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if config.Endpoint != "" {
+		logSubStep("Endpoint: %s", ep)
+	}
 
-		processed[config.Container] = true
+	// Create temporary working directory
+	tempDir := filepath.Join(archiveTempRoot, archiveTempPrefix+strings.ReplaceAll(configKey(config), "/", "_")+"-"+time.Now().Format("20060102150405"))
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	archive := &entryArchive{config: config, tempDir: tempDir}
+
+	switch {
+	case config.Project != "":
+		archive.snapshots, err = backupProject(ep, config.Project, shouldStop(config), tempDir, opts.RedactEnv)
+	case config.Volume != "":
+		err = backupNamedVolume(ep, config.Volume, tempDir)
+	default:
+		archive.snapshots, err = backupContainer(ep, config.Container, stop, tempDir, opts.RedactEnv)
+	}
+	return archive, err
+}
+
+// isArchiveTempDir reports whether dir is the temporary directory of an
+// entry archive
+func isArchiveTempDir(dir string) bool {
+	return filepath.Dir(dir) == archiveTempRoot && strings.HasPrefix(filepath.Base(dir), archiveTempPrefix)
+}
+
+// cleanup removes the temporary directory of the archive, unless the
+// archive is kept for resuming its upload
+func (a *entryArchive) cleanup() {
+	if a.resumable {
+		logStep("💾 Keeping %s to resume its upload on the next start", a.tempDir)
+		return
+	}
+	logStep("🧹 Cleaning up temporary directory: %s", a.tempDir)
+	if err := os.RemoveAll(a.tempDir); err != nil {
+		logSubStep("⚠️  Failed to remove temporary directory %s: %v", a.tempDir, err)
+	}
+}
+
+// upload sends the archive and its manifest to Dropbox and applies the
+// retention policy
+func (a *entryArchive) upload(uploader *DropboxUploader, opts RunOptions) error {
+	if uploader == nil {
 		return nil
 	}
 
-	// Process all containers
-	for _, config := range configs {
-		if err := processContainer(config); err != nil {
-			return err
+	logHeader("📤 Uploading backup to Dropbox...")
+	timestamp := time.Now().Format("20060102.150405")
+	backupFileName := timestamp + ".7z"
+	localBackupPath := filepath.Join(a.tempDir, configName(a.config)+".7z")
+
+	// Use the helper function to get the backup ID
+	backupID := getBackupID(a.config)
+	dropboxTargetPath := filepath.Join(opts.DropboxPath, backupID, backupFileName)
+
+	if !strings.HasPrefix(dropboxTargetPath, "/") {
+		dropboxTargetPath = "/" + dropboxTargetPath
+	}
+
+	logStep("📁 Uploading to Dropbox: %s", dropboxTargetPath)
+	forget := uploader.expectEntry(dropboxTargetPath, a.config, a.snapshots)
+	metadata, err := uploader.Upload(localBackupPath, dropboxTargetPath)
+	forget()
+	if err != nil {
+		// The next start resumes the session and finishes the backup
+		a.resumable = uploader.hasPendingUpload(dropboxTargetPath)
+		return fmt.Errorf("dropbox upload failed: %v", err)
+	}
+	logStep("✅ Backup successfully uploaded to Dropbox")
+	return a.finish(uploader, opts, dropboxTargetPath, metadata)
+}
+
+// finish completes the backup of an uploaded archive: it is reported, the
+// container configuration is stored next to it and the retention policy
+// is applied
+func (a *entryArchive) finish(uploader *DropboxUploader, opts RunOptions, dropboxTargetPath string, metadata *DropboxEntry) error {
+	opts.Report.uploaded(a.config, metadata)
+	backupID := getBackupID(a.config)
+
+	// Store container configuration next to the archive
+	if len(a.snapshots) > 0 {
+		manifest := BackupManifest{
+			BackupID:   backupID,
+			Archive:    path.Base(dropboxTargetPath),
+			Containers: a.snapshots,
+		}
+		manifestPath := strings.TrimSuffix(dropboxTargetPath, ".7z") + manifestSuffix
+		if err := uploadManifest(uploader, manifest, a.tempDir, manifestPath); err != nil {
+			return fmt.Errorf("manifest upload failed: %v", err)
+		}
+	}
+
+	// Apply retention policy
+	retentionPolicy := a.config.retentionPolicy(opts.Retention)
+	if retentionPolicy.enabled() {
+		// Use the helper function here as well
+		retentionPath := filepath.Join(opts.DropboxPath, backupID)
+		if err := manageRetention(uploader, retentionPath, retentionPolicy, opts.Trash, false); err != nil {
+			return fmt.Errorf("retention management failed: %v", err)
 		}
 	}
 
 	return nil
 }
 
+// backupContainer archives the volumes of a single container, stopping it
+// for the duration of the backup if stop is set. The container
+// configuration is captured and archived alongside the volumes.
+func backupContainer(ep *DockerEndpoint, container string, stop bool, tempDir string, redactEnv bool) (snapshots []ContainerSnapshot, err error) {
+	snapshot, err := captureContainerSnapshot(ep, container, redactEnv)
+	if err != nil {
+		return nil, err
+	}
+	snapshots = []ContainerSnapshot{*snapshot}
+	if err := writeSnapshots(snapshots, stagingDir(tempDir, container)); err != nil {
+		return nil, err
+	}
+
+	// Stop container if required. It is started again on every path, so
+	// a failed backup does not leave it down.
+	if stop {
+		if err := stopDockerContainer(ep, container); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if startErr := startDockerContainer(ep, container); startErr != nil && err == nil {
+				snapshots, err = nil, startErr
+			}
+		}()
+	}
+
+	// Get and process volumes
+	volumeResult, err := getContainerVolumes(ep, container)
+	if err != nil {
+		return nil, err
+	}
+	if volumeResult.Status == "Failed" {
+		return nil, fmt.Errorf("failed to get container volumes: %s", volumeResult.Error)
+	}
+
+	if err := processVolumes(ep, container, volumeResult.Volumes, tempDir); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// configName returns the name identifying a configuration entry: the
+// container name, the compose project name or the volume name
+func configName(config ContainerConfig) string {
+	switch {
+	case config.Project != "":
+		return config.Project
+	case config.Volume != "":
+		return config.Volume
+	}
+	return config.Container
+}
+
+// configKey identifies an entry across endpoints, since the same name may
+// exist on several hosts. Volume keys are prefixed with "volume:" so a
+// volume never collides with a container of the same name.
+func configKey(config ContainerConfig) string {
+	name := configName(config)
+	if config.Volume != "" {
+		name = "volume:" + name
+	}
+	if config.Endpoint == "" {
+		return name
+	}
+	return config.Endpoint + "/" + name
+}
+
+// dependencyKey returns the key of a dependency, which is looked up on the
+// endpoint of the entry that depends on it
+func dependencyKey(config ContainerConfig, dep string) string {
+	if config.Endpoint == "" {
+		return dep
+	}
+	return config.Endpoint + "/" + dep
+}
+
+// getBackupID returns the folder backups of an entry are stored in. Entries
+// on named endpoints default to a folder per endpoint, and volumes default
+// to a "volumes" subfolder.
 func getBackupID(config ContainerConfig) string {
 	if config.BackupID != nil && *config.BackupID != "" {
 		return *config.BackupID
 	}
-	return config.Container
+	name := configName(config)
+	if config.Volume != "" {
+		name = "volumes/" + name
+	}
+	if config.Endpoint == "" {
+		return name
+	}
+	return config.Endpoint + "/" + name
 }
 
 func shouldStop(config ContainerConfig) bool {
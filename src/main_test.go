@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLongDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"36h", 36 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"1w2d30m", 9*24*time.Hour + 30*time.Minute},
+		{"1.5d", 36 * time.Hour},
+		{".5w", 84 * time.Hour},
+		{"0.1d", 2*time.Hour + 24*time.Minute},
+		{"1.5d6h", 42 * time.Hour},
+		{"2.5h", 150 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseLongDuration(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseLongDuration(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseLongDurationRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"no unit", "30"},
+		{"no number", "d"},
+		{"unknown unit", "1y"},
+		{"lone dot", ".d"},
+		{"two dots", "1.5.5d"},
+		{"trailing dot", "1d."},
+		{"repeated unit", "1dd"},
+		{"unit first", "d1"},
+		{"exponent", "1e3d"},
+		{"overflow", "99999999w"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseLongDuration(tt.value); err == nil {
+				t.Errorf("parseLongDuration(%q) = %v, want an error", tt.value, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const dropboxAuthorizeURL = "https://www.dropbox.com/oauth2/authorize"
+
+// runAuth implements the auth command, which walks through the OAuth2
+// code flow with PKCE and prints a refresh token for DROPBOX_REFRESH_TOKEN
+func runAuth(args []string) {
+	if len(args) == 0 || args[0] != "dropbox" {
+		fmt.Fprintln(os.Stderr, "usage: volback auth dropbox [-client-id ID] [-client-secret SECRET] [-output FILE]")
+		os.Exit(2)
+	}
+
+	logHeader("=== Dropbox Authorization ===")
+
+	fs := flag.NewFlagSet("auth dropbox", flag.ExitOnError)
+	clientID := fs.String("client-id", os.Getenv("DROPBOX_CLIENT_ID"), "Dropbox app key")
+	clientSecret := fs.String("client-secret", os.Getenv("DROPBOX_CLIENT_SECRET"), "Dropbox app secret; optional with PKCE")
+	output := fs.String("output", "", "Append DROPBOX_REFRESH_TOKEN=<token> to this env file instead of only printing it")
+	fs.Parse(args[1:])
+
+	if *clientID == "" {
+		logStep("❌ -client-id or DROPBOX_CLIENT_ID is required")
+		os.Exit(1)
+	}
+
+	verifier, challenge, err := newPKCE()
+	if err != nil {
+		logStep("❌ Failed to create PKCE verifier: %v", err)
+		os.Exit(1)
+	}
+
+	query := url.Values{}
+	query.Set("client_id", *clientID)
+	query.Set("response_type", "code")
+	query.Set("token_access_type", "offline")
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+
+	logStep("🌐 Open this URL in a browser and allow access:")
+	fmt.Println()
+	fmt.Println("  " + dropboxAuthorizeURL + "?" + query.Encode())
+	fmt.Println()
+	fmt.Print("Enter the authorization code: ")
+
+	code, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	code = strings.TrimSpace(code)
+	if code == "" {
+		fmt.Println()
+		logStep("❌ No authorization code entered")
+		os.Exit(1)
+	}
+
+	formData := url.Values{}
+	formData.Set("grant_type", "authorization_code")
+	formData.Set("code", code)
+	formData.Set("client_id", *clientID)
+	formData.Set("code_verifier", verifier)
+	if *clientSecret != "" {
+		formData.Set("client_secret", *clientSecret)
+	}
+
+	tokenResp, err := requestToken(http.DefaultClient, defaultDropboxURLs.Token, formData)
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+	if tokenResp.RefreshToken == "" {
+		logStep("❌ Dropbox did not return a refresh token")
+		os.Exit(1)
+	}
+
+	if *output != "" {
+		if err := appendEnvFile(*output, "DROPBOX_REFRESH_TOKEN", tokenResp.RefreshToken); err != nil {
+			logStep("❌ %v", err)
+			os.Exit(1)
+		}
+		logStep("✅ Refresh token written to %s", *output)
+		return
+	}
+
+	logStep("✅ Authorization complete. Set this in volback's environment:")
+	fmt.Println()
+	fmt.Println("  DROPBOX_REFRESH_TOKEN=" + tokenResp.RefreshToken)
+}
+
+// newPKCE returns a random code verifier and its S256 challenge
+func newPKCE() (verifier, challenge string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// appendEnvFile appends KEY=value to an env file readable only by its owner
+func appendEnvFile(path, key, value string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s=%s\n", key, value); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
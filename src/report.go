@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// runReport records the outcome of every entry of a run. Reports are
+// written to the reports folder of the state directory, so uploads can be
+// verified later against their content hash without downloading them.
+type runReport struct {
+	mu       sync.Mutex
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Entries  []reportEntry `json:"entries"`
+}
+
+// reportEntry is the outcome of a single entry
+type reportEntry struct {
+	Key         string    `json:"key"`
+	BackupID    string    `json:"backup_id"`
+	Archive     string    `json:"archive,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Finished    time.Time `json:"finished"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{Started: time.Now().UTC()}
+}
+
+func (r *runReport) add(entry reportEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.Finished = time.Now().UTC()
+	r.Entries = append(r.Entries, entry)
+}
+
+// uploaded records the archive of an entry stored on Dropbox
+func (r *runReport) uploaded(config ContainerConfig, metadata *DropboxEntry) {
+	r.add(reportEntry{
+		Key:         configKey(config),
+		BackupID:    getBackupID(config),
+		Archive:     metadata.Path,
+		Size:        metadata.Size,
+		ContentHash: metadata.ContentHash,
+	})
+}
+
+// failed records an entry whose backup failed
+func (r *runReport) failed(config ContainerConfig, err error) {
+	r.add(reportEntry{
+		Key:      configKey(config),
+		BackupID: getBackupID(config),
+		Error:    err.Error(),
+	})
+}
+
+// save writes the report to reports/<started>.json in the state directory
+func (r *runReport) save(state *stateStore) {
+	if r == nil || state == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Entries) == 0 {
+		return
+	}
+
+	r.Finished = time.Now().UTC()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		logSubStep("⚠️  Failed to marshal run report: %v", err)
+		return
+	}
+
+	dir := filepath.Join(state.dir(), "reports")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logSubStep("⚠️  Failed to create report directory: %v", err)
+		return
+	}
+	path := filepath.Join(dir, r.Started.Format("20060102.150405")+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		logSubStep("⚠️  Failed to write run report: %v", err)
+		return
+	}
+	logStep("📝 Run report written to %s", path)
+}
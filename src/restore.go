@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// runRestoreContainer implements the restore-container command, which
+// recreates containers from the configuration stored with a backup when
+// they no longer exist on this host. Their volume data is restored from
+// the archive before any of them is started, so a compose project comes
+// back as a unit.
+func runRestoreContainer(args []string) {
+	logHeader("=== Docker Container Restore ===")
+
+	fs := flag.NewFlagSet("restore-container", flag.ExitOnError)
+	dropbox := registerDropboxFlags(fs)
+	endpointsJSON := fs.String("endpoints", os.Getenv("ENDPOINTS"), "JSON array of named Docker endpoints")
+	endpointName := fs.String("endpoint", "", "Name of the endpoint to recreate containers on; defaults to DOCKER_HOST")
+	backupID := fs.String("id", "", "Backup ID to restore from")
+	archive := fs.String("archive", "", "Archive timestamp to restore from (e.g., 20240101.030000); defaults to the latest")
+	only := fs.String("container", "", "Only recreate this container from the backup")
+	start := fs.Bool("start", false, "Start recreated containers")
+	restoreData := fs.Bool("volumes", true, "Restore volume data of recreated containers from the archive")
+	fs.Parse(args)
+
+	if *backupID == "" {
+		logStep("❌ -id is required")
+		os.Exit(1)
+	}
+	if !dropbox.valid() {
+		logStep("❌ Dropbox configuration is required")
+		os.Exit(1)
+	}
+
+	endpoints, err := loadEndpoints(*endpointsJSON)
+	if err != nil {
+		logStep("❌ Failed to load Docker endpoints: %v", err)
+		os.Exit(1)
+	}
+	ep, err := endpoints.get(*endpointName)
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+
+	uploader, err := dropbox.newUploader()
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+	backupPath := path.Join(*dropbox.path, *backupID)
+	manifest, err := fetchManifest(uploader, backupPath, *archive)
+	if err != nil {
+		logStep("❌ Failed to fetch backup manifest: %v", err)
+		os.Exit(1)
+	}
+	logStep("📋 Manifest for %s contains %d containers", manifest.Archive, len(manifest.Containers))
+
+	var selected []ContainerSnapshot
+	for _, snapshot := range manifest.Containers {
+		if *only == "" || snapshot.Name == *only {
+			selected = append(selected, snapshot)
+		}
+	}
+
+	// Volumes that already exist hold live data and are left alone; this
+	// is checked before any container creates its volumes
+	existing, err := existingVolumes(ep, selected)
+	if err != nil {
+		logStep("❌ %v", err)
+		os.Exit(1)
+	}
+
+	// Manifests list compose services dependencies first
+	var recreated []ContainerSnapshot
+	for _, snapshot := range selected {
+		created, err := recreateContainer(ep, snapshot)
+		if err != nil {
+			logStep("❌ Failed to recreate container %s: %v", snapshot.Name, err)
+			os.Exit(1)
+		}
+		if created {
+			recreated = append(recreated, snapshot)
+		}
+	}
+
+	// Data goes in before anything is started
+	if *restoreData && len(recreated) > 0 {
+		if err := restoreVolumes(ep, uploader, path.Join(backupPath, manifest.Archive), recreated, existing); err != nil {
+			logStep("❌ Failed to restore volume data: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *start {
+		for _, snapshot := range recreated {
+			if err := startDockerContainer(ep, snapshot.Name); err != nil {
+				os.Exit(1)
+			}
+		}
+	}
+
+	logHeader("✨ Restore completed successfully!")
+}
+
+// fetchManifest downloads the manifest for the given archive timestamp, or
+// the latest manifest in backupPath when timestamp is empty
+func fetchManifest(uploader *DropboxUploader, backupPath, timestamp string) (*BackupManifest, error) {
+	if err := uploader.ensureValidToken(); err != nil {
+		return nil, fmt.Errorf("failed to ensure valid token: %v", err)
+	}
+
+	manifestPath := path.Join(backupPath, timestamp+manifestSuffix)
+	if timestamp == "" {
+		manifests, err := uploader.ListFilesWithSuffix(backupPath, manifestSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if len(manifests) == 0 {
+			return nil, fmt.Errorf("no manifests found in %s", backupPath)
+		}
+		// Timestamped names sort chronologically
+		sort.Strings(manifests)
+		manifestPath = manifests[len(manifests)-1]
+	}
+
+	logStep("📥 Downloading manifest: %s", manifestPath)
+	var buf bytes.Buffer
+	if err := uploader.Download(manifestPath, &buf); err != nil {
+		return nil, err
+	}
+
+	var manifest BackupManifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	return &manifest, nil
+}
+
+// recreateContainer creates a container from a snapshot unless a container
+// with the same name already exists, and reports whether it was created
+func recreateContainer(ep *DockerEndpoint, snapshot ContainerSnapshot) (bool, error) {
+	logHeader("📦 Restoring container: %s", snapshot.Name)
+
+	inspect := snapshot.Inspect
+	if inspect.ContainerJSONBase == nil || inspect.Config == nil {
+		return false, fmt.Errorf("snapshot is missing container configuration")
+	}
+
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return false, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Image pulls can take a while, so allow more time than other calls
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if _, err := cli.ContainerInspect(ctx, snapshot.Name); err == nil {
+		logStep("⏭️  Container %s already exists, skipping", snapshot.Name)
+		return false, nil
+	} else if !client.IsErrNotFound(err) {
+		return false, fmt.Errorf("error inspecting container: %v", err)
+	}
+
+	if snapshot.Redacted {
+		logStep("⚠️  Environment was redacted at backup time; secrets are set to %s", redactedValue)
+	}
+
+	// Prefer the pinned digest so the exact image is restored
+	config := *inspect.Config
+	if snapshot.ImageDigest != "" {
+		config.Image = snapshot.ImageDigest
+	}
+	if err := ensureImage(ctx, cli, config.Image); err != nil {
+		return false, err
+	}
+
+	// A hostname derived from the old container ID would be misleading
+	if len(config.Hostname) == 12 && strings.HasPrefix(inspect.ID, config.Hostname) {
+		config.Hostname = ""
+	}
+
+	// Endpoints are created with configuration only; operational data
+	// such as addresses and IDs belongs to the old container
+	endpoints := make(map[string]*network.EndpointSettings)
+	if inspect.NetworkSettings != nil {
+		for name, settings := range inspect.NetworkSettings.Networks {
+			if settings == nil {
+				continue
+			}
+			if err := ensureNetwork(ctx, cli, name); err != nil {
+				return false, err
+			}
+			endpoints[name] = &network.EndpointSettings{
+				IPAMConfig: settings.IPAMConfig,
+				Links:      settings.Links,
+				Aliases:    settings.Aliases,
+				MacAddress: settings.MacAddress,
+				DriverOpts: settings.DriverOpts,
+			}
+		}
+	}
+
+	// Only the primary network can be attached at creation time on
+	// older engines; the rest are connected afterwards
+	primary := ""
+	if inspect.HostConfig != nil {
+		primary = string(inspect.HostConfig.NetworkMode)
+	}
+	networking := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	if settings, ok := endpoints[primary]; ok {
+		networking.EndpointsConfig[primary] = settings
+		delete(endpoints, primary)
+	}
+
+	logStep("🛠️  Creating container %s from %s", snapshot.Name, config.Image)
+	created, err := cli.ContainerCreate(ctx, &config, inspect.HostConfig, networking, nil, snapshot.Name)
+	if err != nil {
+		return false, fmt.Errorf("error creating container: %v", err)
+	}
+	for _, warning := range created.Warnings {
+		logSubStep("⚠️  %s", warning)
+	}
+
+	for name, settings := range endpoints {
+		logSubStep("🔌 Connecting to network: %s", name)
+		if err := cli.NetworkConnect(ctx, name, created.ID, settings); err != nil {
+			return false, fmt.Errorf("error connecting network %s: %v", name, err)
+		}
+	}
+
+	logStep("✅ Container %s recreated", snapshot.Name)
+	return true, nil
+}
+
+func ensureImage(ctx context.Context, cli *client.Client, ref string) error {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil
+	}
+
+	logSubStep("⬇️  Pulling image %s...", ref)
+	reader, err := cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("error pulling image %s: %v", ref, err)
+	}
+	defer reader.Close()
+
+	// The pull only completes once its progress stream has been consumed
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("error pulling image %s: %v", ref, err)
+	}
+	return nil
+}
+
+func ensureNetwork(ctx context.Context, cli *client.Client, name string) error {
+	switch name {
+	case "bridge", "host", "none":
+		return nil
+	}
+
+	if _, err := cli.NetworkInspect(ctx, name, network.InspectOptions{}); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("error inspecting network %s: %v", name, err)
+	}
+
+	logSubStep("🔌 Creating missing network: %s", name)
+	if _, err := cli.NetworkCreate(ctx, name, network.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating network %s: %v", name, err)
+	}
+	return nil
+}
+
+// existingVolumes returns the named volumes mounted by the snapshots that
+// already exist on the endpoint
+func existingVolumes(ep *DockerEndpoint, snapshots []ContainerSnapshot) (map[string]bool, error) {
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	existing := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		for _, mount := range snapshot.Inspect.Mounts {
+			if mount.Type != "volume" || mount.Name == "" {
+				continue
+			}
+			if _, err := cli.VolumeInspect(ctx, mount.Name); err == nil {
+				existing[mount.Name] = true
+			} else if !client.IsErrNotFound(err) {
+				return nil, fmt.Errorf("error inspecting volume %s: %v", mount.Name, err)
+			}
+		}
+	}
+	return existing, nil
+}
+
+// restoreVolumes downloads an archive and extracts the data of every mount
+// of the snapshots, except the volumes in keep. The archive holds one
+// inner archive per mount, named after the base64 encoded mount source.
+func restoreVolumes(ep *DockerEndpoint, uploader *DropboxUploader, archivePath string, snapshots []ContainerSnapshot, keep map[string]bool) error {
+	logHeader("💾 Restoring volume data from %s", archivePath)
+
+	tempDir, err := os.MkdirTemp("", "volback-restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	localArchive := filepath.Join(tempDir, "archive.7z")
+	file, err := os.Create(localArchive)
+	if err != nil {
+		return fmt.Errorf("failed to create local archive: %v", err)
+	}
+	logStep("📥 Downloading archive: %s", archivePath)
+	err = uploader.Download(archivePath, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download archive: %v", err)
+	}
+
+	if err := pullLatestPackmateImage(ep); err != nil {
+		return err
+	}
+
+	// The archive is unpacked in a volume on the engine, so remote
+	// endpoints work the same way as the local one
+	suffix := time.Now().Format("20060102150405")
+	stageVolume := "volback-restore-" + suffix
+	helper := "volback-restore-copy-" + suffix
+	if _, err := executeCommand("docker", ep.cliArgs("volume", "create", stageVolume)...); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", stageVolume, err)
+	}
+	defer executeCommand("docker", ep.cliArgs("volume", "rm", "-f", stageVolume)...)
+
+	// The helper is never started; docker cp works on created containers
+	if _, err := executeCommand("docker", ep.cliArgs("create", "--name", helper, "-v", stageVolume+":/stage", packmateImage)...); err != nil {
+		return fmt.Errorf("failed to create helper container: %w", err)
+	}
+	defer executeCommand("docker", ep.cliArgs("rm", "-f", helper)...)
+
+	if _, err := executeCommand("docker", ep.cliArgs("cp", localArchive, helper+":/stage/archive.7z")...); err != nil {
+		return fmt.Errorf("failed to copy archive: %w", err)
+	}
+	if err := extractArchive(ep, stageVolume, "/stage/archive.7z", stageVolume, "/stage/volumes"); err != nil {
+		return fmt.Errorf("failed to unpack archive: %w", err)
+	}
+
+	restored := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		for _, mount := range snapshot.Inspect.Mounts {
+			// Named volumes are mounted by name, bind mounts by host path
+			target := mount.Source
+			if mount.Type == "volume" {
+				target = mount.Name
+			}
+			if volumeSkipReason(Volume{Source: mount.Source, Type: string(mount.Type)}) != "" || restored[target] {
+				continue
+			}
+			restored[target] = true
+			if keep[target] {
+				logStep("⏭️  Volume %s already exists, keeping its data", target)
+				continue
+			}
+
+			logStep("💾 Restoring %s of %s into %s", mount.Destination, snapshot.Name, target)
+			inner := "/stage/volumes/" + base64.StdEncoding.EncodeToString([]byte(mount.Source)) + ".7z"
+			if err := extractArchive(ep, stageVolume, inner, target, "/target"); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// extractArchive unpacks an archive in the source volume into dest, which
+// is mounted at mountPoint. Both are volume names or host paths on the
+// engine.
+func extractArchive(ep *DockerEndpoint, source, archive, dest, mountPoint string) error {
+	args := []string{"run", "--rm", "--entrypoint", "7z", "-v", source + ":/stage"}
+	if dest != source {
+		args = append(args, "-v", dest+":"+mountPoint)
+	}
+	args = append(args, packmateImage, "x", "-y", "-o"+mountPoint, archive)
+	_, err := executeCommand("docker", ep.cliArgs(args...)...)
+	return err
+}
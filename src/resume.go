@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// uploadSessionLifetime is how long Dropbox keeps an upload session open.
+// Sessions are not resumed during their last hour.
+const uploadSessionLifetime = 7 * 24 * time.Hour
+
+// errUploadSessionNotFound is returned when Dropbox no longer knows a
+// session, e.g. because it expired
+var errUploadSessionNotFound = errors.New("upload session not found")
+
+// incorrectOffsetError is returned by an append whose offset does not
+// match what Dropbox has stored for the session
+type incorrectOffsetError struct {
+	correct int64
+}
+
+func (e *incorrectOffsetError) Error() string {
+	return fmt.Sprintf("incorrect offset, server expects %d", e.correct)
+}
+
+// pendingUpload is an upload session in progress, persisted so that it can
+// be resumed after a restart
+type pendingUpload struct {
+	Source     string    `json:"source"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	SessionID  string    `json:"session_id"`
+	Started    time.Time `json:"started"`
+	ChunkSize  int64     `json:"chunk_size"`
+	Concurrent bool      `json:"concurrent,omitempty"`
+	Offset     int64     `json:"offset,omitempty"` // bytes stored by a sequential session
+	Chunks     []int64   `json:"chunks,omitempty"` // chunks stored by a concurrent session
+
+	// The entry whose archive is uploaded, so that its backup can be
+	// finished once the upload is resumed
+	Entry     *ContainerConfig    `json:"entry,omitempty"`
+	Snapshots []ContainerSnapshot `json:"snapshots,omitempty"`
+}
+
+// pendingEntry is the entry an upload in progress belongs to
+type pendingEntry struct {
+	config    ContainerConfig
+	snapshots []ContainerSnapshot
+}
+
+// resumedUpload is an interrupted upload that has been completed
+type resumedUpload struct {
+	Target   string
+	Upload   pendingUpload
+	Metadata *DropboxEntry
+}
+
+// expired reports whether the session is too old to be resumed
+func (p pendingUpload) expired(now time.Time) bool {
+	return now.Sub(p.Started) > uploadSessionLifetime-time.Hour
+}
+
+// matches reports whether file is still the file the session uploads
+func (p pendingUpload) matches(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return p.Source == file.Name() && p.Size == info.Size() && p.ModTime.Equal(info.ModTime())
+}
+
+// newPendingUpload describes a session that was just started for file
+func newPendingUpload(file *os.File, sessionID string, chunkSize int64, concurrent bool) (pendingUpload, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return pendingUpload{}, fmt.Errorf("failed to stat file: %v", err)
+	}
+	return pendingUpload{
+		Source:     file.Name(),
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		SessionID:  sessionID,
+		Started:    time.Now().UTC(),
+		ChunkSize:  chunkSize,
+		Concurrent: concurrent,
+	}, nil
+}
+
+// pendingUpload returns the resumable session uploading file to
+// targetPath, if there is one
+func (d *DropboxUploader) pendingUpload(file *os.File, targetPath string, chunkSize int64, concurrent bool) *pendingUpload {
+	if d.State == nil {
+		return nil
+	}
+	state, err := d.State.load()
+	if err != nil {
+		logSubStep("⚠️  %v", err)
+		return nil
+	}
+	p, ok := state.Uploads[targetPath]
+	if !ok || p.expired(time.Now()) || !p.matches(file) || p.ChunkSize != chunkSize || p.Concurrent != concurrent {
+		return nil
+	}
+	return &p
+}
+
+// updatePendingUpload applies fn to the stored session of targetPath
+func (d *DropboxUploader) updatePendingUpload(targetPath string, fn func(p *pendingUpload)) {
+	if d.State == nil {
+		return
+	}
+	err := d.State.update(func(state *runState) {
+		p := state.Uploads[targetPath]
+		fn(&p)
+		state.Uploads[targetPath] = p
+	})
+	if err != nil {
+		logSubStep("⚠️  Failed to record upload progress: %v", err)
+	}
+}
+
+// expectEntry records that the upload to targetPath backs up config. The
+// entry is stored together with the upload session as soon as the session
+// starts, so the backup can be finished after a resume even if the process
+// died mid-upload. The returned function forgets the entry again.
+func (d *DropboxUploader) expectEntry(targetPath string, config ContainerConfig, snapshots []ContainerSnapshot) func() {
+	d.entriesMu.Lock()
+	defer d.entriesMu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]pendingEntry)
+	}
+	d.entries[targetPath] = pendingEntry{config: config, snapshots: snapshots}
+
+	return func() {
+		d.entriesMu.Lock()
+		defer d.entriesMu.Unlock()
+		delete(d.entries, targetPath)
+	}
+}
+
+// startPendingUpload stores a session that was just started, along with
+// the entry expected for targetPath
+func (d *DropboxUploader) startPendingUpload(targetPath string, pending pendingUpload) {
+	d.entriesMu.Lock()
+	if entry, ok := d.entries[targetPath]; ok {
+		pending.Entry = &entry.config
+		pending.Snapshots = entry.snapshots
+	}
+	d.entriesMu.Unlock()
+
+	d.updatePendingUpload(targetPath, func(p *pendingUpload) { *p = pending })
+}
+
+// hasPendingUpload reports whether an interrupted upload to targetPath can
+// be resumed, in which case its source file must be kept
+func (d *DropboxUploader) hasPendingUpload(targetPath string) bool {
+	if d.State == nil {
+		return false
+	}
+	state, err := d.State.load()
+	if err != nil {
+		logSubStep("⚠️  %v", err)
+		return false
+	}
+	_, ok := state.Uploads[targetPath]
+	return ok
+}
+
+// forgetPendingUpload removes the stored session of targetPath
+func (d *DropboxUploader) forgetPendingUpload(targetPath string) {
+	if d.State == nil {
+		return
+	}
+	err := d.State.update(func(state *runState) {
+		delete(state.Uploads, targetPath)
+	})
+	if err != nil {
+		logSubStep("⚠️  Failed to record upload progress: %v", err)
+	}
+}
+
+// ResumePendingUploads finishes uploads interrupted by a restart whose
+// source file is unchanged and whose session has not expired, and returns
+// them. Their source files are left for the caller to remove. Sessions
+// that cannot be resumed are dropped.
+func (d *DropboxUploader) ResumePendingUploads() []resumedUpload {
+	if d.State == nil {
+		return nil
+	}
+	state, err := d.State.load()
+	if err != nil {
+		logStep("⚠️  %v", err)
+		return nil
+	}
+	if len(state.Uploads) == 0 {
+		return nil
+	}
+
+	targets := make([]string, 0, len(state.Uploads))
+	for target := range state.Uploads {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	logHeader("🔁 Found %d interrupted uploads", len(targets))
+	var resumed []resumedUpload
+	for _, target := range targets {
+		p := state.Uploads[target]
+		if p.expired(time.Now()) {
+			logStep("⏭️  Dropping expired upload session for %s", target)
+			d.forgetPendingUpload(target)
+			continue
+		}
+
+		metadata, err := d.resumeUpload(target, p)
+		if err != nil {
+			logStep("⚠️  Failed to resume upload of %s: %v", target, err)
+			continue
+		}
+		resumed = append(resumed, resumedUpload{Target: target, Upload: p, Metadata: metadata})
+	}
+	return resumed
+}
+
+func (d *DropboxUploader) resumeUpload(target string, p pendingUpload) (*DropboxEntry, error) {
+	file, err := os.Open(p.Source)
+	if err != nil {
+		d.forgetPendingUpload(target)
+		return nil, fmt.Errorf("source file is gone: %v", err)
+	}
+	defer file.Close()
+	if !p.matches(file) {
+		d.forgetPendingUpload(target)
+		return nil, fmt.Errorf("source file %s has changed", p.Source)
+	}
+
+	if err := d.ensureValidToken(); err != nil {
+		return nil, fmt.Errorf("failed to ensure valid token: %v", err)
+	}
+
+	logStep("🔁 Resuming upload of %s", p.Source)
+	logSubStep("Target path: %s", target)
+	var metadata *DropboxEntry
+	if p.Concurrent {
+		metadata, err = d.uploadLargeFileConcurrent(file, target, p.Size, p.ChunkSize)
+	} else {
+		metadata, err = d.uploadLargeFile(file, target, p.Size, p.ChunkSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyContentHash(file, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"volback/internal/fakedropbox"
+)
+
+func TestInterruptedBackupIsFinishedAfterResume(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	backup := registerBackupFlags(fs)
+	if err := fs.Parse([]string{"-state-dir", t.TempDir(), "-dropbox-path", "/backups", "-keep-last", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	uploader.State = backup.store()
+	uploader.ChunkSize = dropboxChunkUnit
+	server.PutFile("/backups/app/20200101.000000.7z", []byte("old"), time.Now())
+
+	tempDir := t.TempDir()
+	source, data := writeTestFile(t, 2*dropboxChunkUnit+5)
+	if err := os.Rename(source, filepath.Join(tempDir, "app.7z")); err != nil {
+		t.Fatal(err)
+	}
+	archive := &entryArchive{
+		config:    ContainerConfig{Container: "app"},
+		tempDir:   tempDir,
+		snapshots: []ContainerSnapshot{{Name: "app"}},
+	}
+
+	// The upload stops after the first chunk
+	server.FailNext("/2/files/upload_session/append_v2", fakedropbox.Failure{
+		Status: 409,
+		Body:   `{"error_summary": "other/..", "error": {".tag": "other"}}`,
+	})
+	if err := archive.upload(uploader, backup.runOptions(nil)); err == nil {
+		t.Fatal("upload succeeded despite the failure")
+	}
+	archive.cleanup()
+	if _, err := os.Stat(filepath.Join(tempDir, "app.7z")); err != nil {
+		t.Fatalf("archive of the interrupted upload was removed: %v", err)
+	}
+
+	backup.resumeUploads(uploader)
+
+	var archivePath, manifestPath string
+	for _, p := range server.Paths() {
+		switch {
+		case p == "/backups/app/20200101.000000.7z":
+			t.Error("retention did not run after the resumed upload")
+		case strings.HasSuffix(p, ".7z"):
+			archivePath = p
+		case strings.HasSuffix(p, manifestSuffix):
+			manifestPath = p
+		}
+	}
+	if archivePath == "" || manifestPath != strings.TrimSuffix(archivePath, ".7z")+manifestSuffix {
+		t.Fatalf("server holds %v, want the archive and its manifest", server.Paths())
+	}
+	assertStored(t, server, archivePath, data)
+	if n := server.Calls("/2/files/upload_session/start"); n != 1 {
+		t.Errorf("started %d upload sessions, want the first one resumed", n)
+	}
+
+	if _, ok := backup.store().lastSuccess("app"); !ok {
+		t.Error("the resumed backup was not recorded as successful")
+	}
+	if reports, _ := os.ReadDir(filepath.Join(backup.store().dir(), "reports")); len(reports) != 1 {
+		t.Errorf("wrote %d run reports, want 1", len(reports))
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory was kept after the resume: %v", err)
+	}
+}
+
+func TestBackupIsFinishedAfterDyingMidUpload(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	backup := registerBackupFlags(fs)
+	if err := fs.Parse([]string{"-state-dir", t.TempDir(), "-dropbox-path", "/backups"}); err != nil {
+		t.Fatal(err)
+	}
+	uploader.State = backup.store()
+	uploader.ChunkSize = dropboxChunkUnit
+
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "app.7z")
+	written, data := writeTestFile(t, 2*dropboxChunkUnit+5)
+	if err := os.Rename(written, source); err != nil {
+		t.Fatal(err)
+	}
+	config := ContainerConfig{Container: "app"}
+	target := "/backups/app/20250102.030405.7z"
+
+	// The process dies after the first chunk: nothing runs after the
+	// failed append, so the entry must have been stored with the session
+	server.FailNext("/2/files/upload_session/append_v2", fakedropbox.Failure{
+		Status: 409,
+		Body:   `{"error_summary": "other/..", "error": {".tag": "other"}}`,
+	})
+	uploader.expectEntry(target, config, []ContainerSnapshot{{Name: "app"}})
+	if _, err := uploader.Upload(source, target); err == nil {
+		t.Fatal("upload succeeded despite the failure")
+	}
+	state, err := backup.store().load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := state.Uploads[target]; p.Entry == nil || configKey(*p.Entry) != "app" || len(p.Snapshots) != 1 {
+		t.Fatalf("stored upload %+v does not name its entry", p)
+	}
+
+	// A new process resumes with an uploader of its own
+	restarted := NewDropboxUploader(server.RefreshToken, "client-id", "client-secret")
+	restarted.URLs, restarted.HTTPClient = uploader.URLs, uploader.HTTPClient
+	restarted.State = backup.store()
+	restarted.ChunkSize = dropboxChunkUnit
+	backup.resumeUploads(restarted)
+
+	assertStored(t, server, target, data)
+	if _, ok := server.File(strings.TrimSuffix(target, ".7z") + manifestSuffix); !ok {
+		t.Error("the manifest of the resumed backup was not uploaded")
+	}
+	if _, ok := backup.store().lastSuccess("app"); !ok {
+		t.Error("the resumed backup was not recorded as successful")
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("temporary directory was kept after the resume: %v", err)
+	}
+}
@@ -2,6 +2,10 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -11,27 +15,122 @@ import (
 type Backup struct {
 	Path     string
 	DateTime time.Time
+	Entry    DropboxEntry
 }
 
 func parseBackupDateTime(filename string) (time.Time, error) {
 	// Remove .7z extension if present
 	filename = strings.TrimSuffix(filename, ".7z")
 
-	// Try to parse the timestamp
-	return time.Parse("20060102.150405", filename)
+	// Try to parse the timestamp, written in local time
+	return time.ParseInLocation("20060102.150405", filename, time.Local)
 }
 
-func manageRetention(uploader *DropboxUploader, backupPath string, policy RetentionPolicy) error {
+// manageRetention deletes the backups in backupPath the policy does not
+// keep. A dry run only reports what would be kept and deleted.
+func manageRetention(uploader *DropboxUploader, backupPath string, policy RetentionPolicy, trash TrashOptions, dryRun bool) error {
 	logHeader("🧹 Managing backup retention...")
+	keeping, deleting := "Keeping", "Deleting"
+	if dryRun {
+		logStep("🧪 Dry run: nothing will be deleted")
+		keeping, deleting = "Would keep", "Would delete"
+	}
 
-	files, err := uploader.ListFiles(backupPath)
+	backups, hasManifest, err := listBackups(uploader, backupPath)
 	if err != nil {
 		return err
 	}
+	if len(backups) == 0 {
+		logStep("ℹ️  No backups found to process")
+		return nil
+	}
+
+	toKeep := selectRetained(backups, policy, time.Now())
+	counts := make(map[string]int)
+	for _, backup := range backups {
+		reasons := toKeep[backup.Path]
+		for _, reason := range reasons {
+			counts[reason]++
+		}
+		if len(reasons) > 0 {
+			logSubStep("📌 %s backup: %s (%s)", keeping, filepath.Base(backup.Path), strings.Join(reasons, ", "))
+		}
+	}
+
+	// Delete unneeded backups together with their manifests
+	var expired []string
+	for _, backup := range backups {
+		if len(toKeep[backup.Path]) == 0 {
+			logSubStep("🗑️  %s backup: %s (not selected by any rule)", deleting, filepath.Base(backup.Path))
+			expired = append(expired, backup.Path)
+			manifest := strings.TrimSuffix(backup.Path, ".7z") + manifestSuffix
+			if hasManifest[manifest] {
+				expired = append(expired, manifest)
+			}
+		}
+	}
+
+	deletedCount := 0
+	switch {
+	case dryRun:
+		deletedCount = len(backups) - len(toKeep)
+	case len(expired) > 0:
+		if err := removeExpired(uploader, backupPath, expired, trash); err != nil {
+			logSubStep("⚠️  Failed to delete backups: %v", err)
+		} else {
+			deletedCount = len(backups) - len(toKeep)
+		}
+	}
+	if trash.Enabled {
+		if err := purgeTrash(uploader, backupPath, trash.Grace, dryRun); err != nil {
+			logSubStep("⚠️  Failed to purge trash: %v", err)
+		}
+	}
+
+	// Log detailed summary
+	logStep("📊 Retention Summary:")
+	for _, rule := range policy.rules() {
+		if rule.count > 0 {
+			logSubStep("%-8s %d/%d", rule.name+":", counts[rule.name], rule.count)
+		}
+	}
+	if policy.KeepWithin > 0 {
+		logSubStep("%-8s %d (within %s of the latest)", "within:", counts["within"], policy.KeepWithin)
+	}
+	if policy.MinAge > 0 {
+		logSubStep("%-8s %d (younger than %s)", "min-age:", counts["min-age"], policy.MinAge)
+	}
+	if dryRun {
+		logStep("🧪 Retention dry run completed. Would keep %d backups, would delete %d backups", len(toKeep), deletedCount)
+		return nil
+	}
+	logStep("✅ Retention completed. Kept %d backups, deleted %d backups", len(toKeep), deletedCount)
+
+	return nil
+}
+
+// listBackups returns the archives in backupPath, newest first, and the
+// manifests stored next to them
+func listBackups(uploader *DropboxUploader, backupPath string) ([]Backup, map[string]bool, error) {
+	entries, err := uploader.ListFolder(backupPath, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Manifests stored next to archives are deleted together with them
+	hasManifest := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsFile() && strings.HasSuffix(entry.Name, manifestSuffix) {
+			hasManifest[entry.Path] = true
+		}
+	}
 
 	var backups []Backup
-	for _, file := range files {
-		filename := filepath.Base(file)
+	for _, entry := range entries {
+		if !entry.IsFile() || !strings.HasSuffix(entry.Name, ".7z") {
+			continue
+		}
+		filename := entry.Name
 		if !strings.HasPrefix(filename, "202") {
 			logSubStep("⚠️  Skipping invalid filename: %s", filename)
 			continue
@@ -43,112 +142,221 @@ func manageRetention(uploader *DropboxUploader, backupPath string, policy Retent
 			continue
 		}
 
-		backups = append(backups, Backup{Path: file, DateTime: t})
-	}
-
-	if len(backups) == 0 {
-		logStep("ℹ️  No backups found to process")
-		return nil
+		backups = append(backups, Backup{Path: entry.Path, DateTime: t, Entry: entry})
 	}
 
 	// Sort backups by date (newest first)
+	sortBackups(backups)
+	return backups, hasManifest, nil
+}
+
+// sortBackups orders backups newest first
+func sortBackups(backups []Backup) {
 	sort.Slice(backups, func(i, j int) bool {
 		return backups[i].DateTime.After(backups[j].DateTime)
 	})
+}
 
-	toKeep := make(map[string]bool)
-	backupCategories := make(map[string]string) // Track which category each backup belongs to
-
-	// Always keep most recent backup
-	mostRecent := backups[0]
-	toKeep[mostRecent.Path] = true
-	backupCategories[mostRecent.Path] = "most_recent"
-	logSubStep("📌 Keeping most recent backup: %s", filepath.Base(mostRecent.Path))
+// UnmarshalJSON reads a policy whose durations are strings that may use
+// days and weeks. Numbers of nanoseconds are accepted as well, as state
+// files of earlier versions hold them.
+func (p *RetentionPolicy) UnmarshalJSON(data []byte) error {
+	type plain RetentionPolicy
+	var raw struct {
+		plain
+		KeepWithin json.RawMessage `json:"keep_within"`
+		MinAge     json.RawMessage `json:"min_age"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
 
-	// Helper function to check if backup belongs to a different period
-	isDifferentPeriod := func(t1, t2 time.Time, format string) bool {
-		return t1.Format(format) != t2.Format(format)
+	policy := RetentionPolicy(raw.plain)
+	var err error
+	if policy.KeepWithin, err = parseJSONDuration(raw.KeepWithin); err != nil {
+		return fmt.Errorf("invalid keep_within: %v", err)
+	}
+	if policy.MinAge, err = parseJSONDuration(raw.MinAge); err != nil {
+		return fmt.Errorf("invalid min_age: %v", err)
 	}
+	*p = policy
+	return nil
+}
 
-	// Process remaining backups
-	remainingBackups := backups[1:]
+// MarshalJSON writes the durations of a policy as strings, so that the
+// policy reads back through UnmarshalJSON
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	type plain RetentionPolicy
+	raw := struct {
+		plain
+		KeepWithin string `json:"keep_within,omitempty"`
+		MinAge     string `json:"min_age,omitempty"`
+	}{plain: plain(p)}
+	if p.KeepWithin != 0 {
+		raw.KeepWithin = p.KeepWithin.String()
+	}
+	if p.MinAge != 0 {
+		raw.MinAge = p.MinAge.String()
+	}
+	return json.Marshal(raw)
+}
 
-	// Daily retention (different day than most recent)
-	for _, b := range remainingBackups {
-		if !toKeep[b.Path] && isDifferentPeriod(b.DateTime, mostRecent.DateTime, "2006-01-02") {
-			toKeep[b.Path] = true
-			backupCategories[b.Path] = "daily"
-			logSubStep("📌 Keeping daily backup: %s (different day)", filepath.Base(b.Path))
-			break
+// parseJSONDuration reads a duration given as a string or as a number of
+// nanoseconds. An absent value is zero.
+func parseJSONDuration(data json.RawMessage) (time.Duration, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return 0, fmt.Errorf("invalid duration %s", data)
 		}
+		return time.Duration(n), nil
 	}
+	if s == "" {
+		return 0, nil
+	}
+	return parseLongDuration(s)
+}
 
-	// Weekly retention (different week than most recent)
-	for _, b := range remainingBackups {
-		if !toKeep[b.Path] && isDifferentPeriod(b.DateTime, mostRecent.DateTime, "2006-W02") {
-			toKeep[b.Path] = true
-			backupCategories[b.Path] = "weekly"
-			logSubStep("📌 Keeping weekly backup: %s (different week)", filepath.Base(b.Path))
-			break
-		}
+// retentionPolicy returns the policy of an entry: its own if it has one,
+// otherwise the global policy
+func (c ContainerConfig) retentionPolicy(global RetentionPolicy) RetentionPolicy {
+	if c.Retention != nil {
+		return *c.Retention
 	}
+	return global
+}
 
-	// Monthly retention (different month than most recent)
-	for _, b := range remainingBackups {
-		if !toKeep[b.Path] && isDifferentPeriod(b.DateTime, mostRecent.DateTime, "2006-01") {
-			toKeep[b.Path] = true
-			backupCategories[b.Path] = "monthly"
-			logSubStep("📌 Keeping monthly backup: %s (different month)", filepath.Base(b.Path))
-			break
-		}
+// retentionRule keeps the newest backup of each of the count most recent
+// periods that contain a backup
+type retentionRule struct {
+	name   string
+	count  int
+	period func(t time.Time) string
+}
+
+// rules returns the count based rules of the policy: the latest backups
+// and the grandfather-father-son buckets. Backup names are unique per
+// second, so every backup is a period of its own for keep-last.
+func (p RetentionPolicy) rules() []retentionRule {
+	return []retentionRule{
+		{"last", p.KeepLast, func(t time.Time) string { return t.Format(time.RFC3339) }},
+		{"hourly", p.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{"daily", p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
 	}
+}
 
-	// Yearly retention (different year than most recent)
-	for _, b := range remainingBackups {
-		if !toKeep[b.Path] && isDifferentPeriod(b.DateTime, mostRecent.DateTime, "2006") {
-			toKeep[b.Path] = true
-			backupCategories[b.Path] = "yearly"
-			logSubStep("📌 Keeping yearly backup: %s (different year)", filepath.Base(b.Path))
-			break
+// enabled reports whether the policy selects backups to delete
+func (p RetentionPolicy) enabled() bool {
+	for _, rule := range p.rules() {
+		if rule.count > 0 {
+			return true
 		}
 	}
+	return p.KeepWithin > 0
+}
+
+// selectRetained returns the backups to keep, mapped to the rules that
+// keep them. Each rule walks the backups from newest to oldest and keeps
+// the first one of every period until it has kept count backups. Backups
+// within KeepWithin of the most recent one and backups younger than MinAge
+// at now are kept as well. The most recent backup is always kept.
+func selectRetained(backups []Backup, policy RetentionPolicy, now time.Time) map[string][]string {
+	sorted := append([]Backup(nil), backups...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].DateTime.After(sorted[j].DateTime)
+	})
+
+	keep := make(map[string][]string)
+	if len(sorted) == 0 {
+		return keep
+	}
+	keep[sorted[0].Path] = append(keep[sorted[0].Path], "latest")
 
-	// Delete unneeded backups
-	deletedCount := 0
-	for _, backup := range backups {
-		if !toKeep[backup.Path] {
-			logSubStep("🗑️  Deleting backup: %s (from same period as existing backup)",
-				filepath.Base(backup.Path))
-			if err := uploader.DeleteFile(backup.Path); err != nil {
-				logSubStep("⚠️  Failed to delete backup %s: %v", filepath.Base(backup.Path), err)
-			} else {
-				deletedCount++
+	for _, rule := range policy.rules() {
+		remaining := rule.count
+		last := ""
+		for _, backup := range sorted {
+			if remaining <= 0 {
+				break
+			}
+			if period := rule.period(backup.DateTime); period != last {
+				keep[backup.Path] = append(keep[backup.Path], rule.name)
+				last = period
+				remaining--
 			}
 		}
 	}
 
-	// Count backups by their assigned categories
-	counts := map[string]int{
-		"daily":   0,
-		"weekly":  0,
-		"monthly": 0,
-		"yearly":  0,
+	for _, backup := range sorted {
+		if policy.KeepWithin > 0 && sorted[0].DateTime.Sub(backup.DateTime) <= policy.KeepWithin {
+			keep[backup.Path] = append(keep[backup.Path], "within")
+		}
+		if policy.MinAge > 0 && now.Sub(backup.DateTime) < policy.MinAge {
+			keep[backup.Path] = append(keep[backup.Path], "min-age")
+		}
 	}
+	return keep
+}
 
-	for _, category := range backupCategories {
-		if category != "most_recent" {
-			counts[category]++
-		}
+// trashFolder holds expired backups of a backup folder until they are purged
+const trashFolder = ".trash"
+
+// removeExpired deletes expired files in one batch, or moves them to
+// .trash/<date> in the backup folder when the trash is enabled
+func removeExpired(uploader *DropboxUploader, backupPath string, paths []string, trash TrashOptions) error {
+	if !trash.Enabled {
+		return uploader.DeleteBatch(paths)
 	}
 
-	// Log detailed summary
-	logStep("📊 Retention Summary:")
-	logSubStep("Most Recent: 1")
-	logSubStep("Daily: %d/%d (from different days)", counts["daily"], policy.KeepDaily)
-	logSubStep("Weekly: %d/%d (from different weeks)", counts["weekly"], policy.KeepWeekly)
-	logSubStep("Monthly: %d/%d (from different months)", counts["monthly"], policy.KeepMonthly)
-	logSubStep("Yearly: %d/%d (from different years)", counts["yearly"], policy.KeepYearly)
-	logStep("✅ Retention completed. Kept %d backups, deleted %d backups", len(toKeep), deletedCount)
+	dir := path.Join("/", backupPath, trashFolder, time.Now().UTC().Format("2006-01-02"))
+	logSubStep("🗑️  Moving %d files to %s", len(paths), dir)
+	var moves []dropboxRelocation
+	for _, p := range paths {
+		moves = append(moves, dropboxRelocation{FromPath: p, ToPath: path.Join(dir, path.Base(p))})
+	}
+	return uploader.MoveBatch(moves)
+}
 
-	return nil
+// purgeTrash deletes the trash folders of a backup folder that are older
+// than the grace period. A dry run only lists them.
+func purgeTrash(uploader *DropboxUploader, backupPath string, grace time.Duration, dryRun bool) error {
+	entries, err := uploader.ListFolder(path.Join("/", backupPath, trashFolder), false)
+	if errors.Is(err, errDropboxNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().Add(-grace)
+	var purge []string
+	for _, entry := range entries {
+		day, err := time.Parse("2006-01-02", entry.Name)
+		if entry.Tag != "folder" || err != nil {
+			continue
+		}
+		// A folder is purged once its whole day is past the grace period
+		if day.Add(24 * time.Hour).Before(cutoff) {
+			if dryRun {
+				logSubStep("🗑️  Would purge trash from %s", entry.Name)
+				continue
+			}
+			logSubStep("🗑️  Purging trash from %s", entry.Name)
+			purge = append(purge, entry.Path)
+		}
+	}
+	if len(purge) == 0 {
+		return nil
+	}
+	return uploader.DeleteBatch(purge)
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupsAt returns backups named after their timestamps
+func backupsAt(t *testing.T, stamps ...string) []Backup {
+	t.Helper()
+	var backups []Backup
+	for _, stamp := range stamps {
+		dt, err := parseBackupDateTime(stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, Backup{Path: stamp + ".7z", DateTime: dt})
+	}
+	return backups
+}
+
+func TestSelectRetained(t *testing.T) {
+	tests := []struct {
+		name    string
+		backups []string
+		policy  RetentionPolicy
+		now     string // defaults to long after the backups
+		want    map[string][]string
+	}{
+		{
+			name:    "newest of each day",
+			backups: []string{"20250105.100000", "20250105.080000", "20250104.230000", "20250104.010000", "20250102.120000", "20250101.120000"},
+			policy:  RetentionPolicy{KeepDaily: 3},
+			want: map[string][]string{
+				"20250105.100000.7z": {"latest", "daily"},
+				"20250104.230000.7z": {"daily"},
+				"20250102.120000.7z": {"daily"},
+			},
+		},
+		{
+			name:    "input order does not matter",
+			backups: []string{"20250101.120000", "20250104.010000", "20250105.080000", "20250102.120000", "20250105.100000", "20250104.230000"},
+			policy:  RetentionPolicy{KeepDaily: 2},
+			want: map[string][]string{
+				"20250105.100000.7z": {"latest", "daily"},
+				"20250104.230000.7z": {"daily"},
+			},
+		},
+		{
+			name:    "ISO weeks across the year boundary",
+			backups: []string{"20241230.120000", "20241229.120000", "20241228.120000", "20241222.120000"},
+			policy:  RetentionPolicy{KeepWeekly: 2},
+			want: map[string][]string{
+				"20241230.120000.7z": {"latest", "weekly"}, // 2025-W01
+				"20241229.120000.7z": {"weekly"},           // 2024-W52
+			},
+		},
+		{
+			name:    "months and years",
+			backups: []string{"20250310.000000", "20250301.000000", "20250215.000000", "20241231.000000", "20230601.000000"},
+			policy:  RetentionPolicy{KeepMonthly: 2, KeepYearly: 2},
+			want: map[string][]string{
+				"20250310.000000.7z": {"latest", "monthly", "yearly"},
+				"20250215.000000.7z": {"monthly"},
+				"20241231.000000.7z": {"yearly"},
+			},
+		},
+		{
+			name:    "rules overlap",
+			backups: []string{"20250115.000000", "20250114.000000", "20250108.000000", "20250101.000000"},
+			policy:  RetentionPolicy{KeepDaily: 2, KeepWeekly: 2},
+			want: map[string][]string{
+				"20250115.000000.7z": {"latest", "daily", "weekly"},
+				"20250114.000000.7z": {"daily"},
+				"20250108.000000.7z": {"weekly"},
+			},
+		},
+		{
+			name:    "fewer backups than the counts",
+			backups: []string{"20250103.000000", "20250102.000000", "20250101.000000"},
+			policy:  RetentionPolicy{KeepDaily: 7, KeepWeekly: 4},
+			want: map[string][]string{
+				"20250103.000000.7z": {"latest", "daily", "weekly"},
+				"20250102.000000.7z": {"daily"},
+				"20250101.000000.7z": {"daily"},
+			},
+		},
+		{
+			name:    "last backups",
+			backups: []string{"20250105.100000", "20250105.080000", "20250104.230000"},
+			policy:  RetentionPolicy{KeepLast: 2},
+			want: map[string][]string{
+				"20250105.100000.7z": {"latest", "last"},
+				"20250105.080000.7z": {"last"},
+			},
+		},
+		{
+			name:    "newest of each hour",
+			backups: []string{"20250105.103000", "20250105.101500", "20250105.090000", "20250105.084500", "20250105.070000"},
+			policy:  RetentionPolicy{KeepHourly: 2},
+			want: map[string][]string{
+				"20250105.103000.7z": {"latest", "hourly"},
+				"20250105.090000.7z": {"hourly"},
+			},
+		},
+		{
+			name:    "within a week of the latest",
+			backups: []string{"20250110.000000", "20250105.000000", "20250103.000000", "20250101.000000"},
+			policy:  RetentionPolicy{KeepWithin: 7 * 24 * time.Hour},
+			want: map[string][]string{
+				"20250110.000000.7z": {"latest", "within"},
+				"20250105.000000.7z": {"within"},
+				"20250103.000000.7z": {"within"},
+			},
+		},
+		{
+			name:    "minimum age",
+			backups: []string{"20250110.000000", "20250109.060000", "20250108.000000"},
+			policy:  RetentionPolicy{KeepLast: 1, MinAge: 36 * time.Hour},
+			now:     "20250110.120000",
+			want: map[string][]string{
+				"20250110.000000.7z": {"latest", "last", "min-age"},
+				"20250109.060000.7z": {"min-age"},
+			},
+		},
+		{
+			name:    "empty policy keeps the latest",
+			backups: []string{"20250102.000000", "20250101.000000"},
+			want: map[string][]string{
+				"20250102.000000.7z": {"latest"},
+			},
+		},
+		{
+			name: "no backups",
+			want: map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.Local)
+			if tt.now != "" {
+				now = backupsAt(t, tt.now)[0].DateTime
+			}
+			got := selectRetained(backupsAt(t, tt.backups...), tt.policy, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectRetained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionPlanIncludesNewArchive(t *testing.T) {
+	existing := backupsAt(t, "20250102.000000", "20250101.000000")
+	now := backupsAt(t, "20250103.000000")[0].DateTime
+
+	backups, toKeep, err := retentionPlan(existing, "/backups/app/20250103.000000.7z", RetentionPolicy{KeepLast: 2}, now)
+	if err != nil {
+		t.Fatalf("retentionPlan: %v", err)
+	}
+	if len(backups) != 3 || backups[0].Path != "/backups/app/20250103.000000.7z" {
+		t.Fatalf("planned backups %v, want the new archive first", backups)
+	}
+	want := map[string][]string{
+		"/backups/app/20250103.000000.7z": {"latest", "last"},
+		"20250102.000000.7z":              {"last"},
+	}
+	if !reflect.DeepEqual(toKeep, want) {
+		t.Errorf("retentionPlan kept %v, want %v", toKeep, want)
+	}
+}
+
+func TestContainerRetentionOverride(t *testing.T) {
+	var configs ContainerConfigs
+	err := json.Unmarshal([]byte(`[
+		{"container": "db", "retention": {"keep_daily": 30, "keep_within": "2w", "min_age": "36h"}},
+		{"container": "cache"}
+	]`), &configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	global := RetentionPolicy{KeepDaily: 2}
+	want := RetentionPolicy{KeepDaily: 30, KeepWithin: 14 * 24 * time.Hour, MinAge: 36 * time.Hour}
+	if got := configs[0].retentionPolicy(global); got != want {
+		t.Errorf("db policy = %+v, want %+v", got, want)
+	}
+	if got := configs[1].retentionPolicy(global); got != global {
+		t.Errorf("cache policy = %+v, want the global %+v", got, global)
+	}
+
+	if err := json.Unmarshal([]byte(`{"keep_within": "soon"}`), &RetentionPolicy{}); err == nil {
+		t.Error("an invalid keep_within was accepted")
+	}
+}
+
+func TestRetentionPolicyRoundTrip(t *testing.T) {
+	policy := RetentionPolicy{KeepDaily: 7, KeepWithin: 36 * time.Hour, MinAge: 90 * time.Minute}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"keep_within":"36h0m0s"`; !strings.Contains(string(data), want) {
+		t.Errorf("Marshal = %s, want durations as strings such as %s", data, want)
+	}
+	var got RetentionPolicy
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal(%s): %v", data, err)
+	}
+	if got != policy {
+		t.Errorf("round trip through %s = %+v, want %+v", data, got, policy)
+	}
+
+	// Earlier versions wrote durations as nanoseconds
+	if err := json.Unmarshal([]byte(`{"keep_within": 3600000000000}`), &got); err != nil || got.KeepWithin != time.Hour {
+		t.Errorf("numeric keep_within = %v, %v; want 1h", got.KeepWithin, err)
+	}
+}
+
+func TestRetentionFromLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    *RetentionPolicy
+		wantErr bool
+	}{
+		{"no retention labels", map[string]string{labelEnable: "true"}, nil, false},
+		{
+			"counts and durations",
+			map[string]string{labelKeepDaily: "7", labelKeepHourly: " 24 ", labelKeepWithin: "30d"},
+			&RetentionPolicy{KeepHourly: 24, KeepDaily: 7, KeepWithin: 30 * 24 * time.Hour},
+			false,
+		},
+		{"zero counts disable retention", map[string]string{labelKeepLast: "0"}, &RetentionPolicy{}, false},
+		{"invalid count", map[string]string{labelKeepWeekly: "many"}, nil, true},
+		{"negative count", map[string]string{labelKeepYearly: "-1"}, nil, true},
+		{"invalid duration", map[string]string{labelMinAge: "old"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := retentionFromLabels(tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retentionFromLabels() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retentionFromLabels() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManageRetentionDeletesArchivesAndManifests(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	now := time.Now()
+	for _, name := range []string{"20250102.010000", "20250102.020000", "20250102.030000"} {
+		server.PutFile("/backups/app/"+name+".7z", []byte(name), now)
+		server.PutFile("/backups/app/"+name+manifestSuffix, []byte("{}"), now)
+	}
+	server.PutFile("/backups/app/notes.txt", []byte("kept"), now)
+
+	policy := RetentionPolicy{KeepDaily: 1, KeepWeekly: 1, KeepMonthly: 1, KeepYearly: 1}
+	if err := manageRetention(uploader, "/backups/app", policy, TrashOptions{}, false); err != nil {
+		t.Fatalf("manageRetention: %v", err)
+	}
+
+	want := []string{
+		"/backups/app/20250102.030000.7z",
+		"/backups/app/20250102.030000" + manifestSuffix,
+		"/backups/app/notes.txt",
+	}
+	if got := server.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server holds %v, want %v", got, want)
+	}
+}
+
+func TestManageRetentionMovesToTrash(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	now := time.Now()
+	server.PutFile("/backups/app/20250102.010000.7z", []byte("old"), now)
+	server.PutFile("/backups/app/20250102.020000.7z", []byte("new"), now)
+	server.PutFile("/backups/app/.trash/2020-01-01/20200101.000000.7z", []byte("purged"), now)
+
+	policy := RetentionPolicy{KeepDaily: 1}
+	trash := TrashOptions{Enabled: true, Grace: 24 * time.Hour}
+	if err := manageRetention(uploader, "/backups/app", policy, trash, false); err != nil {
+		t.Fatalf("manageRetention: %v", err)
+	}
+
+	want := []string{
+		"/backups/app/.trash/" + time.Now().UTC().Format("2006-01-02") + "/20250102.010000.7z",
+		"/backups/app/20250102.020000.7z",
+	}
+	if got := server.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server holds %v, want %v", got, want)
+	}
+}
+
+func TestManageRetentionDryRun(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	now := time.Now()
+	server.PutFile("/backups/app/20250102.010000.7z", []byte("old"), now)
+	server.PutFile("/backups/app/20250102.020000.7z", []byte("new"), now)
+	server.PutFile("/backups/app/.trash/2020-01-01/20200101.000000.7z", []byte("trashed"), now)
+	before := server.Paths()
+
+	trash := TrashOptions{Enabled: true, Grace: 24 * time.Hour}
+	if err := manageRetention(uploader, "/backups/app", RetentionPolicy{KeepLast: 1}, trash, true); err != nil {
+		t.Fatalf("manageRetention: %v", err)
+	}
+	if got := server.Paths(); !reflect.DeepEqual(got, before) {
+		t.Errorf("dry run changed the server from %v to %v", before, got)
+	}
+	for _, endpoint := range []string{"/2/files/delete_batch", "/2/files/move_batch_v2", "/2/files/delete_v2"} {
+		if n := server.Calls(endpoint); n != 0 {
+			t.Errorf("dry run made %d requests to %s", n, endpoint)
+		}
+	}
+}
+
+func TestManageRetentionWithoutBackups(t *testing.T) {
+	uploader, server := newTestUploader(t)
+	server.PutFile("/backups/other/20250102.010000.7z", []byte("x"), time.Now())
+
+	if err := manageRetention(uploader, "/backups/app", RetentionPolicy{KeepDaily: 1}, TrashOptions{}, false); err == nil {
+		t.Fatal("manageRetention succeeded on a missing folder")
+	}
+	if n := server.Calls("/2/files/delete_batch"); n != 0 {
+		t.Errorf("made %d delete requests", n)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry policy for Dropbox requests
+const (
+	retryAttempts = 6
+	retryBaseWait = time.Second
+	retryMaxWait  = time.Minute
+)
+
+// retryableErrorTags are Dropbox error tags of transient failures. They may
+// appear anywhere in the error_summary of a 409 response.
+var retryableErrorTags = []string{
+	"too_many_write_operations",
+	"too_many_requests",
+	"internal_error",
+	"rate_limit",
+}
+
+// dropboxErrorBody is the part of a Dropbox error response needed to
+// decide whether to retry
+type dropboxErrorBody struct {
+	ErrorSummary string `json:"error_summary"`
+	Error        struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"error"`
+}
+
+// do sends an authenticated request through sendWithRetry
+func (d *DropboxUploader) do(build func() (*http.Request, error)) (*http.Response, error) {
+	return sendWithRetry(context.Background(), d.client, build)
+}
+
+// doUpload sends an upload request, which is cancelled once the uploader's
+// context is done. Other requests are short and still go through, so that
+// e.g. locks are released on shutdown.
+func (d *DropboxUploader) doUpload(build func() (*http.Request, error)) (*http.Response, error) {
+	ctx := d.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return sendWithRetry(ctx, d.client, build)
+}
+
+// sendWithRetry sends the request returned by build, retrying connection
+// errors, 429 and 5xx responses and transient Dropbox errors with capped
+// exponential backoff and jitter. build is called for every attempt so the
+// body can be replayed. Any other response is returned to the caller, as
+// is the last one once the attempts are used up. A failed token refresh
+// is not retried. Cancelling ctx aborts the request and any wait between
+// attempts.
+func sendWithRetry(ctx context.Context, client *http.Client, build func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+		req = req.WithContext(ctx)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			var refreshErr *tokenRefreshError
+			if attempt >= retryAttempts || ctx.Err() != nil || errors.As(err, &refreshErr) {
+				return nil, err
+			}
+			wait := backoff(attempt)
+			logSubStep("⚠️  Request to %s failed: %v, retrying in %s (attempt %d/%d)", req.URL.Path, err, wait.Round(100*time.Millisecond), attempt+1, retryAttempts)
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 && resp.StatusCode != http.StatusConflict {
+			return resp, nil
+		}
+
+		// Read the body to decide; it is handed back if no retry happens
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		var errBody dropboxErrorBody
+		json.Unmarshal(body, &errBody)
+		if resp.StatusCode == http.StatusConflict && !isRetryableSummary(errBody.ErrorSummary) {
+			return resp, nil
+		}
+		if attempt >= retryAttempts {
+			return resp, nil
+		}
+
+		wait := backoff(attempt)
+		if after := retryAfter(resp, errBody); after > 0 {
+			wait = after
+		}
+		reason := errBody.ErrorSummary
+		if reason == "" {
+			reason = resp.Status
+		}
+		logSubStep("⚠️  Request to %s failed: %s, retrying in %s (attempt %d/%d)", req.URL.Path, reason, wait.Round(100*time.Millisecond), attempt+1, retryAttempts)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error once
+// ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the wait before the next attempt: exponential growth
+// capped at retryMaxWait, with full jitter on the upper half
+func backoff(attempt int) time.Duration {
+	wait := retryBaseWait << (attempt - 1)
+	if wait > retryMaxWait || wait <= 0 {
+		wait = retryMaxWait
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retryAfter returns the wait requested by the server through the
+// Retry-After header or the retry_after field of the error
+func retryAfter(resp *http.Response, errBody dropboxErrorBody) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Retry-After")); err == nil {
+		return time.Until(t)
+	}
+	if errBody.Error.RetryAfter > 0 {
+		return time.Duration(errBody.Error.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+func isRetryableSummary(summary string) bool {
+	for _, tag := range strings.Split(summary, "/") {
+		for _, retryable := range retryableErrorTags {
+			if strings.TrimSpace(strings.TrimRight(tag, ".")) == retryable {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// manifestSuffix is the extension of the manifest stored next to an archive
+const manifestSuffix = ".json"
+
+// redactedValue replaces secret environment values in snapshots
+const redactedValue = "<redacted>"
+
+// Environment variable names containing one of these are treated as secrets
+var secretEnvMarkers = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE"}
+
+// ContainerSnapshot is the configuration of a container captured at backup
+// time, complete enough to recreate the container on another host
+type ContainerSnapshot struct {
+	Name        string              `json:"name"`
+	ImageDigest string              `json:"image_digest,omitempty"`
+	CapturedAt  time.Time           `json:"captured_at"`
+	Redacted    bool                `json:"redacted,omitempty"`
+	Inspect     types.ContainerJSON `json:"inspect"`
+}
+
+// BackupManifest is stored next to each archive as <timestamp>.json
+type BackupManifest struct {
+	BackupID   string              `json:"backup_id"`
+	Archive    string              `json:"archive"`
+	Containers []ContainerSnapshot `json:"containers"`
+}
+
+// captureContainerSnapshot inspects a container and its image
+func captureContainerSnapshot(ep *DockerEndpoint, containerName string, redactEnv bool) (*ContainerSnapshot, error) {
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	inspect, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting container %s: %v", containerName, err)
+	}
+
+	snapshot := &ContainerSnapshot{
+		Name:       strings.TrimPrefix(inspect.Name, "/"),
+		CapturedAt: time.Now().UTC(),
+		Inspect:    inspect,
+	}
+
+	// The repo digest pins the exact image the container was running
+	image, _, err := cli.ImageInspectWithRaw(ctx, inspect.Image)
+	if err != nil {
+		logSubStep("⚠️  Failed to inspect image of %s: %v", containerName, err)
+	} else if len(image.RepoDigests) > 0 {
+		snapshot.ImageDigest = image.RepoDigests[0]
+	}
+
+	if redactEnv && inspect.Config != nil {
+		inspect.Config.Env = redactEnvironment(inspect.Config.Env)
+		snapshot.Redacted = true
+	}
+
+	return snapshot, nil
+}
+
+// redactEnvironment replaces the values of variables that look like secrets
+func redactEnvironment(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, found := strings.Cut(entry, "=")
+		if found && isSecretEnv(key) {
+			entry = key + "=" + redactedValue
+		}
+		redacted = append(redacted, entry)
+	}
+	return redacted
+}
+
+func isSecretEnv(key string) bool {
+	key = strings.ToUpper(key)
+	for _, marker := range secretEnvMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSnapshots stores each snapshot as <container>.container.json in dir
+// so that it ends up inside the archive
+func writeSnapshots(snapshots []ContainerSnapshot, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		data, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal snapshot of %s: %v", snapshot.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, snapshot.Name+".container.json"), data, 0644); err != nil {
+			return fmt.Errorf("failed to write snapshot of %s: %v", snapshot.Name, err)
+		}
+	}
+	return nil
+}
+
+// uploadManifest writes the manifest to tempDir and uploads it to targetPath
+func uploadManifest(uploader *DropboxUploader, manifest BackupManifest, tempDir, targetPath string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+
+	localPath := filepath.Join(tempDir, filepath.Base(targetPath))
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	logStep("📁 Uploading container configuration: %s", targetPath)
+	_, err = uploader.Upload(localPath, targetPath)
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// runState is persisted between runs in the state directory
+type runState struct {
+	LastSuccess map[string]time.Time     `json:"last_success"`
+	Uploads     map[string]pendingUpload `json:"uploads,omitempty"` // keyed by target path
+}
+
+// stateStore reads and writes state.json in the state directory. Mount
+// the directory as a volume to keep it across container restarts.
+type stateStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newStateStore(dir string) *stateStore {
+	return &stateStore{path: filepath.Join(dir, "state.json")}
+}
+
+// dir returns the state directory
+func (s *stateStore) dir() string {
+	return filepath.Dir(s.path)
+}
+
+func (s *stateStore) load() (runState, error) {
+	state := runState{
+		LastSuccess: make(map[string]time.Time),
+		Uploads:     make(map[string]pendingUpload),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state: %v", err)
+	}
+	if state.LastSuccess == nil {
+		state.LastSuccess = make(map[string]time.Time)
+	}
+	if state.Uploads == nil {
+		state.Uploads = make(map[string]pendingUpload)
+	}
+	return state, nil
+}
+
+// update applies fn to the stored state and writes it back atomically
+func (s *stateStore) update(fn func(state *runState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load()
+	if err != nil {
+		return err
+	}
+	fn(&state)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// lastSuccess returns when an entry was last backed up successfully
+func (s *stateStore) lastSuccess(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load()
+	if err != nil {
+		logSubStep("⚠️  %v", err)
+		return time.Time{}, false
+	}
+	t, ok := state.LastSuccess[key]
+	return t, ok
+}
+
+// recordSuccess stores the time of a successful backup of an entry
+func (s *stateStore) recordSuccess(key string, t time.Time) {
+	err := s.update(func(state *runState) {
+		state.LastSuccess[key] = t.UTC()
+	})
+	if err != nil {
+		logSubStep("⚠️  Failed to record successful backup of %s: %v", key, err)
+	}
+}
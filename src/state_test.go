@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateKeepsPendingEntryRetention(t *testing.T) {
+	store := newStateStore(t.TempDir())
+	policy := RetentionPolicy{KeepLast: 3, KeepWithin: 14 * 24 * time.Hour, MinAge: 36 * time.Hour}
+	err := store.update(func(state *runState) {
+		state.Uploads["/backups/db/20250102.030405.7z"] = pendingUpload{
+			SessionID: "session",
+			Entry:     &ContainerConfig{Container: "db", Retention: &policy},
+		}
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	state, err := store.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	entry := state.Uploads["/backups/db/20250102.030405.7z"].Entry
+	if entry == nil || entry.Retention == nil || *entry.Retention != policy {
+		t.Fatalf("stored entry = %+v, want retention %+v", entry, policy)
+	}
+}
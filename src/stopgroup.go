@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stop modes
+const (
+	stopEntry = "entry"
+	stopGroup = "group"
+)
+
+// processStopGroup backs up the entries of a run as one group. Containers
+// marked stop are all stopped up front, dependents before their
+// dependencies, and started again in the opposite order once every archive
+// is created, so that an application never runs against a stopped
+// database. Uploads happen after the containers are back up. Compose
+// projects keep stopping their own services.
+func processStopGroup(ctx context.Context, configs ContainerConfigs, uploader *DropboxUploader, opts RunOptions) error {
+	ordered := topologicalOrder(configs)
+
+	var toStop ContainerConfigs
+	for _, config := range ordered {
+		if config.Project == "" && config.Volume == "" && shouldStop(config) {
+			toStop = append(toStop, config)
+		}
+	}
+
+	logHeader("🛑 Stopping %d containers of the group", len(toStop))
+	var stopped ContainerConfigs
+	startGroup := func() error {
+		if len(stopped) == 0 {
+			return nil
+		}
+		logHeader("▶️  Starting %d containers of the group", len(stopped))
+		var firstErr error
+		for i := len(stopped) - 1; i >= 0; i-- {
+			if err := startConfig(stopped[i], opts); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		stopped = nil
+		return firstErr
+	}
+	// Stopped containers are started again on every path; normally this
+	// has already happened before the uploads
+	defer func() {
+		if err := startGroup(); err != nil {
+			logStep("❌ %v", err)
+		}
+	}()
+
+	for i := len(toStop) - 1; i >= 0; i-- {
+		if err := stopConfig(toStop[i], opts); err != nil {
+			return err
+		}
+		stopped = append(stopped, toStop[i])
+	}
+
+	// Archive every entry while the group is stopped
+	var mu sync.Mutex
+	archives := make(map[string]*entryArchive)
+	defer func() {
+		for _, archive := range archives {
+			archive.cleanup()
+		}
+	}()
+	archiveErr := runDAG(configs, opts.Concurrency, func(config ContainerConfig) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		archive, err := archiveEntry(config, opts, false)
+		if archive != nil {
+			mu.Lock()
+			archives[configKey(config)] = archive
+			mu.Unlock()
+		}
+		if err != nil {
+			opts.Report.failed(config, err)
+		}
+		return err
+	})
+
+	// Containers are started again even if archiving failed
+	if err := startGroup(); err != nil {
+		if archiveErr != nil {
+			logStep("❌ %v", err)
+			return archiveErr
+		}
+		return err
+	}
+	if archiveErr != nil {
+		return archiveErr
+	}
+
+	return runDAG(configs, opts.Concurrency, func(config ContainerConfig) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := archives[configKey(config)].upload(uploader, opts); err != nil {
+			opts.Report.failed(config, err)
+			return err
+		}
+		if opts.State != nil {
+			opts.State.recordSuccess(configKey(config), time.Now())
+		}
+		return nil
+	})
+}
+
+func stopConfig(config ContainerConfig, opts RunOptions) error {
+	ep, err := opts.Endpoints.get(config.Endpoint)
+	if err != nil {
+		return err
+	}
+	if err := stopDockerContainer(ep, config.Container); err != nil {
+		return fmt.Errorf("%s: %v", configKey(config), err)
+	}
+	return nil
+}
+
+func startConfig(config ContainerConfig, opts RunOptions) error {
+	ep, err := opts.Endpoints.get(config.Endpoint)
+	if err != nil {
+		return err
+	}
+	if err := startDockerContainer(ep, config.Container); err != nil {
+		return fmt.Errorf("%s: %v", configKey(config), err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const dropboxCurrentAccountPath = "/2/users/get_current_account"
+
+// pathRootTeam selects the team root namespace, looked up at startup
+const pathRootTeam = "team"
+
+// pathRootHeader returns the Dropbox-API-Path-Root value addressing a
+// namespace. The team root is selected with the root tag, which also
+// checks that it is still the account's root; other namespaces such as
+// team folders are selected by ID.
+func pathRootHeader(namespaceID string, isRoot bool) string {
+	var header map[string]string
+	if isRoot {
+		header = map[string]string{".tag": "root", "root": namespaceID}
+	} else {
+		header = map[string]string{".tag": "namespace_id", "namespace_id": namespaceID}
+	}
+	data, _ := json.Marshal(header)
+	return string(data)
+}
+
+// configurePathRoot sets the namespace all paths are relative to: the
+// team root for "team", or the namespace with the given ID. An empty
+// value keeps the home folder of the account.
+func (d *DropboxUploader) configurePathRoot(root string) error {
+	switch root {
+	case "":
+		return nil
+	case pathRootTeam:
+		home, rootID, err := d.namespaces()
+		if err != nil {
+			return fmt.Errorf("failed to detect team root namespace: %v", err)
+		}
+		if rootID == home {
+			logSubStep("ℹ️  Account has no team space, using its home folder")
+			return nil
+		}
+		logSubStep("👥 Using team root namespace %s", rootID)
+		d.PathRoot = pathRootHeader(rootID, true)
+	default:
+		d.PathRoot = pathRootHeader(root, false)
+	}
+	return nil
+}
+
+// namespaces returns the home and root namespace IDs of the account
+func (d *DropboxUploader) namespaces() (home, root string, err error) {
+	resp, err := d.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", d.URLs.API+dropboxCurrentAccountPath, strings.NewReader("null"))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("account lookup failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var account struct {
+		RootInfo struct {
+			RootNamespaceID string `json:"root_namespace_id"`
+			HomeNamespaceID string `json:"home_namespace_id"`
+		} `json:"root_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return "", "", fmt.Errorf("error decoding response: %v", err)
+	}
+	return account.RootInfo.HomeNamespaceID, account.RootInfo.RootNamespaceID, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestTeamHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		root     string
+		wantRoot string
+	}{
+		{name: "team root", root: pathRootTeam, wantRoot: `{".tag":"root","root":"2"}`},
+		{name: "namespace", root: "123", wantRoot: `{".tag":"namespace_id","namespace_id":"123"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader, server := newTestUploader(t)
+			server.RootNamespace = "2"
+			uploader.SelectUser = "dbmid:member"
+			if err := uploader.configurePathRoot(tt.root); err != nil {
+				t.Fatalf("configurePathRoot: %v", err)
+			}
+
+			source, _ := writeTestFile(t, 1024)
+			if _, err := uploader.Upload(source, "/backups/archive.7z"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if _, err := uploader.ListFolder("/backups", false); err != nil {
+				t.Fatalf("ListFolder: %v", err)
+			}
+
+			// RPC and content requests carry both headers
+			for _, endpoint := range []string{"/2/files/list_folder", "/2/files/upload"} {
+				headers := server.Headers(endpoint)
+				if got := headers.Get("Dropbox-API-Select-User"); got != "dbmid:member" {
+					t.Errorf("%s: Dropbox-API-Select-User = %q, want dbmid:member", endpoint, got)
+				}
+				if got := headers.Get("Dropbox-API-Path-Root"); got != tt.wantRoot {
+					t.Errorf("%s: Dropbox-API-Path-Root = %q, want %s", endpoint, got, tt.wantRoot)
+				}
+			}
+
+			// The OAuth token request does not
+			headers := server.Headers("/oauth2/token")
+			if headers == nil {
+				t.Fatal("no token request was made")
+			}
+			for _, name := range []string{"Dropbox-API-Select-User", "Dropbox-API-Path-Root"} {
+				if got := headers.Get(name); got != "" {
+					t.Errorf("token request carries %s: %s", name, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleWindow applies a rate limit between two times of day. Windows
+// may wrap around midnight, e.g. 22:00-06:00.
+type throttleWindow struct {
+	start, end time.Duration // offsets from midnight
+	limit      rate.Limit
+}
+
+// bandwidthLimiter caps the rate at which request bodies are sent. One
+// limiter is shared by all uploads of a process so that parallel chunks
+// and entries together stay below the cap.
+type bandwidthLimiter struct {
+	mu       sync.Mutex
+	limiter  *rate.Limiter
+	current  rate.Limit
+	fallback rate.Limit
+	windows  []throttleWindow
+}
+
+// newBandwidthLimiter builds a limiter from a rate such as "10MB" or
+// "5Mbit" and an optional schedule such as "08:00-18:00=5Mbit,18:00-22:00=20Mbit".
+// The rate applies outside the scheduled windows; an empty rate or 0 means
+// unlimited. It returns nil if no limit is configured.
+func newBandwidthLimiter(limit, schedule string) (*bandwidthLimiter, error) {
+	fallback, err := parseRate(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var windows []throttleWindow
+	for _, part := range splitList(schedule) {
+		span, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid throttle window %q: expected HH:MM-HH:MM=rate", part)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid throttle window %q: expected HH:MM-HH:MM=rate", part)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttle window %q: %v", part, err)
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttle window %q: %v", part, err)
+		}
+		limit, err := parseRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttle window %q: %v", part, err)
+		}
+		windows = append(windows, throttleWindow{start: start, end: end, limit: limit})
+	}
+
+	if fallback == rate.Inf && len(windows) == 0 {
+		return nil, nil
+	}
+	return &bandwidthLimiter{
+		limiter:  rate.NewLimiter(rate.Inf, 0),
+		current:  rate.Inf,
+		fallback: fallback,
+		windows:  windows,
+	}, nil
+}
+
+// parseRate parses a rate in bytes per second. Byte units (K, KB, M, MB,
+// G, GB) are powers of 1024, bit units (Kbit, Mbit, Gbit) powers of 1000.
+func parseRate(s string) (rate.Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return rate.Inf, nil
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"gbit", 1e9 / 8},
+		{"mbit", 1e6 / 8},
+		{"kbit", 1e3 / 8},
+		{"gb", 1 << 30},
+		{"mb", 1 << 20},
+		{"kb", 1 << 10},
+		{"g", 1 << 30},
+		{"m", 1 << 20},
+		{"k", 1 << 10},
+		{"b", 1},
+	}
+	number, multiplier := strings.ToLower(s), 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	if value == 0 {
+		return rate.Inf, nil
+	}
+	return rate.Limit(value * multiplier), nil
+}
+
+// parseTimeOfDay parses HH:MM into an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// limitAt returns the limit in effect at t; the first matching window wins
+func (l *bandwidthLimiter) limitAt(t time.Time) rate.Limit {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	for _, w := range l.windows {
+		inside := offset >= w.start && offset < w.end
+		if w.end <= w.start {
+			inside = offset >= w.start || offset < w.end
+		}
+		if inside {
+			return w.limit
+		}
+	}
+	return l.fallback
+}
+
+// wait blocks until n bytes may be sent and returns how many of them are
+// covered, which is at most one burst
+func (l *bandwidthLimiter) wait(n int) int {
+	l.mu.Lock()
+	limit := l.limitAt(time.Now())
+	if limit != l.current {
+		l.current = limit
+		l.limiter.SetLimit(limit)
+		// A burst of a quarter second keeps the rate smooth
+		burst := int(limit / 4)
+		if burst < 16*1024 {
+			burst = 16 * 1024
+		}
+		l.limiter.SetBurst(burst)
+		if limit == rate.Inf {
+			logSubStep("🚦 Upload rate: unlimited")
+		} else {
+			logSubStep("🚦 Upload rate limited to %.2f MB/s", float64(limit)/1024/1024)
+		}
+	}
+	limiter := l.limiter
+	l.mu.Unlock()
+
+	if limit == rate.Inf {
+		return n
+	}
+	if burst := limiter.Burst(); n > burst {
+		n = burst
+	}
+	limiter.WaitN(context.Background(), n)
+	return n
+}
+
+// reader wraps r so that reading from it follows the limit. A nil limiter
+// returns r unchanged.
+func (l *bandwidthLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: l}
+}
+
+type throttledReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return t.r.Read(p)
+	}
+	n := t.limiter.wait(len(p))
+	return t.r.Read(p[:n])
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // Volume represents the structure of a volume in the output
 type Volume struct {
 	Source      string `json:"source"`
@@ -34,18 +36,63 @@ type ArchiverResult struct {
 	Error       string `json:"error,omitempty"`
 }
 
+// RunOptions holds the settings shared by every entry of a backup run
+type RunOptions struct {
+	DropboxPath string
+	Retention   RetentionPolicy
+	Trash       TrashOptions
+	RedactEnv   bool
+	Endpoints   DockerEndpoints
+	State       *stateStore
+	Lock        LockOptions
+	Concurrency int
+	StopMode    string // entry or group
+	Report      *runReport
+	DryRun      bool // only show what the run would do
+}
+
+// TrashOptions controls whether expired backups are moved to a trash
+// folder instead of being deleted right away
+type TrashOptions struct {
+	Enabled bool
+	Grace   time.Duration // how long trashed backups are kept
+}
+
+// RetentionPolicy selects the backups of an entry to keep. In JSON the
+// durations are strings such as "30d".
 type RetentionPolicy struct {
-	KeepDaily   int
-	KeepWeekly  int
-	KeepMonthly int
-	KeepYearly  int
+	KeepLast    int           `json:"keep_last,omitempty"`
+	KeepHourly  int           `json:"keep_hourly,omitempty"`
+	KeepDaily   int           `json:"keep_daily,omitempty"`
+	KeepWeekly  int           `json:"keep_weekly,omitempty"`
+	KeepMonthly int           `json:"keep_monthly,omitempty"`
+	KeepYearly  int           `json:"keep_yearly,omitempty"`
+	KeepWithin  time.Duration `json:"keep_within,omitempty"` // keep everything this close to the latest backup
+	MinAge      time.Duration `json:"min_age,omitempty"`     // never delete backups younger than this
 }
 
+// ContainerConfig describes one backup entry. Exactly one of Container,
+// Project (a compose project name) or Volume (a named volume) is set.
 type ContainerConfig struct {
-	Container string   `json:"container"`
+	Container string   `json:"container,omitempty"`
+	Project   string   `json:"project,omitempty"`
+	Volume    string   `json:"volume,omitempty"`
+	Endpoint  string   `json:"endpoint,omitempty"`
+	Schedule  string   `json:"schedule,omitempty"`
 	BackupID  *string  `json:"backup_id,omitempty"`
 	Stop      *bool    `json:"stop,omitempty"`
 	DependsOn []string `json:"depends_on,omitempty"`
+
+	// Retention replaces the global retention policy for this entry
+	Retention *RetentionPolicy `json:"retention,omitempty"`
 }
 
 type ContainerConfigs []ContainerConfig
+
+// VolumeConfig describes a named volume backed up without its container
+type VolumeConfig struct {
+	Volume    string           `json:"volume"`
+	Endpoint  string           `json:"endpoint,omitempty"`
+	BackupID  *string          `json:"backup_id,omitempty"`
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// Label docker sets on anonymous volumes created for a container
+const anonymousVolumeLabel = "com.docker.volume.anonymous"
+
+// listNamedVolumes returns the names of all volumes matching every label
+// filter ("key" or "key=value"). Anonymous volumes and volumes mounted by a
+// running container are left out, since those belong to a container entry.
+func listNamedVolumes(ep *DockerEndpoint, labels []string) ([]string, error) {
+	// Initialize Docker client
+	cli, err := ep.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Docker client: %v", err)
+	}
+	defer cli.Close()
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := filters.NewArgs()
+	for _, label := range labels {
+		args.Add("label", label)
+	}
+
+	resp, err := cli.VolumeList(ctx, volume.ListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes: %v", err)
+	}
+
+	running, err := cli.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("status", "running")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+
+	return unattachedVolumes(resp.Volumes, running), nil
+}
+
+// unattachedVolumes returns the sorted names of the named volumes that none
+// of the given containers mount
+func unattachedVolumes(volumes []*volume.Volume, containers []types.Container) []string {
+	mounted := make(map[string]bool)
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if m.Type == "volume" {
+				mounted[m.Name] = true
+			}
+		}
+	}
+
+	var names []string
+	for _, v := range volumes {
+		if _, ok := v.Labels[anonymousVolumeLabel]; ok {
+			continue
+		}
+		if mounted[v.Name] {
+			continue
+		}
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// volumeConfigs converts volume entries into configuration entries,
+// skipping volumes that are already configured
+func volumeConfigs(configs ContainerConfigs, volumes []VolumeConfig) ContainerConfigs {
+	known := make(map[string]bool)
+	for _, config := range configs {
+		if config.Volume != "" {
+			known[configKey(config)] = true
+		}
+	}
+
+	for _, v := range volumes {
+		config := ContainerConfig{
+			Volume:    strings.TrimSpace(v.Volume),
+			Endpoint:  v.Endpoint,
+			BackupID:  v.BackupID,
+			Retention: v.Retention,
+		}
+		if config.Volume == "" || known[configKey(config)] {
+			continue
+		}
+		known[configKey(config)] = true
+		configs = append(configs, config)
+	}
+
+	return configs
+}
+
+// backupNamedVolume archives a volume directly by name. The Packmate
+// helper container mounts the volume itself, so no other container needs
+// to be running or even exist.
+func backupNamedVolume(ep *DockerEndpoint, name, tempDir string) error {
+	volumes := []Volume{{
+		Source: name,
+		Type:   "volume",
+		Name:   name,
+	}}
+	return processVolumes(ep, name, volumes, tempDir)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+)
+
+func TestUnattachedVolumes(t *testing.T) {
+	volumes := []*volume.Volume{
+		{Name: "logs"},
+		{Name: "db-data"},
+		{Name: "3f9c2a", Labels: map[string]string{anonymousVolumeLabel: ""}},
+		{Name: "archive"},
+	}
+	running := []types.Container{
+		{Mounts: []types.MountPoint{{Type: "volume", Name: "db-data"}}},
+		{Mounts: []types.MountPoint{{Type: "bind", Source: "/srv/logs"}}},
+	}
+
+	got := unattachedVolumes(volumes, running)
+	want := []string{"archive", "logs"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unattachedVolumes = %v, want %v", got, want)
+	}
+}
+
+func TestVolumeNamedLikeContainer(t *testing.T) {
+	configs := volumeConfigs(ContainerConfigs{{Container: "data"}}, []VolumeConfig{{Volume: "data"}})
+	if len(configs) != 2 {
+		t.Fatalf("got %d entries, want 2", len(configs))
+	}
+	if err := validateDependencies(configs); err != nil {
+		t.Fatalf("validateDependencies: %v", err)
+	}
+
+	container, vol := configs[0], configs[1]
+	if configKey(container) == configKey(vol) {
+		t.Errorf("container and volume share key %q", configKey(vol))
+	}
+	if getBackupID(container) == getBackupID(vol) {
+		t.Errorf("container and volume share folder %q", getBackupID(vol))
+	}
+	if got := getBackupID(ContainerConfig{Volume: "data", Endpoint: "nas"}); got != "nas/volumes/data" {
+		t.Errorf("getBackupID = %q, want nas/volumes/data", got)
+	}
+}